@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"gopkg.in/yaml.v3"
+)
+
+// TestPolicyYAML verifies httprate.ParsePolicy with yaml.Unmarshal. Like the
+// chi tests, it lives here to keep the main module free of YAML.
+func TestPolicyYAML(t *testing.T) {
+	p, err := httprate.ParsePolicy([]byte(`
+limiters:
+  - name: api
+    limit: 100
+    window: 1m
+    keys: [ip, endpoint]
+    exempt:
+      ips: [10.0.0.0/8]
+  - name: login
+    limit: 5
+    window: 1m
+    keys: [ip]
+    headers:
+      limit: RateLimit-Limit
+      remaining: ""
+routes:
+  - pattern: /api/
+    limiters: [api]
+  - pattern: POST /login
+    limiters: [api, login]
+`), yaml.Unmarshal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := p.Limiters[1]
+	if login.Limit != 5 || time.Duration(login.Window) != time.Minute || login.Headers["limit"] != "RateLimit-Limit" || len(p.Routes) != 2 {
+		t.Errorf("policy = %+v", p)
+	}
+
+	// Unknown fields are rejected as in JSON, however deep.
+	for _, policy := range []string{
+		"limitters: []",
+		"limiters: [{name: api, limit: 1, window: 1m, exempt: {ipz: [10.0.0.0/8]}}]",
+	} {
+		if _, err := httprate.ParsePolicy([]byte(policy), yaml.Unmarshal); err == nil || !strings.Contains(err.Error(), "unknown field") {
+			t.Errorf("%s: err = %v, want unknown field", policy, err)
+		}
+	}
+}
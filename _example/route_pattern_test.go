@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/httprate"
+)
+
+// TestKeyByRoutePattern_Chi verifies httprate.KeyByRoutePattern against chi's
+// *chi.Context: requests to different paths matching the same route share a
+// bucket. Like the client IP tests, it lives here to keep the main module
+// chi-free.
+func TestKeyByRoutePattern_Chi(t *testing.T) {
+	keyByRoute := httprate.KeyByRoutePattern(func(r *http.Request) httprate.RoutePatterner {
+		return chi.RouteContext(r.Context())
+	}, httprate.KeyRequired())
+
+	r := chi.NewRouter()
+	r.With(httprate.LimitBy(2, time.Minute, keyByRoute)).Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.With(httprate.LimitBy(2, time.Minute, keyByRoute)).Get("/orgs/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	for i, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/users/1", 200},
+		{"/users/2", 200},
+		{"/users/3", 429},
+		{"/orgs/1", 200},
+	} {
+		if got := get(tt.path); got != tt.want {
+			t.Errorf("request %d: GET %s = %d, want %d", i, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package httprate
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdaptiveOptions configures an AdaptiveLimit (see NewAdaptiveLimit).
+type AdaptiveOptions struct {
+	// Name identifies the limit in metrics.
+	Name string
+
+	// Min, Max and Initial bound the limit and set its starting value.
+	// Default: Initial is Max
+	Min, Max, Initial int
+
+	// LatencyTarget is the mean handler latency above which the backend is
+	// considered degraded.
+	// Default: no latency target
+	LatencyTarget time.Duration
+
+	// MaxErrorRate is the share of 5xx responses, from 0 to 1, above which
+	// the backend is considered degraded.
+	// Default: 0.1
+	MaxErrorRate float64
+
+	// Interval is how often the limit is adjusted, from the requests observed
+	// since the last adjustment: decreased by DecreaseFactor if the backend
+	// is degraded, or else increased by Increase (AIMD).
+	// Default: 1s, an Increase of 1% of Max (at least 1) and a DecreaseFactor
+	// of 0.9
+	Interval       time.Duration
+	Increase       int
+	DecreaseFactor float64
+
+	// KeyFunc adapts a limit per key, e.g. per tenant or route, instead of a
+	// single global limit. It must return the same keys as the rate limiter's
+	// KeyFunc.
+	KeyFunc KeyFunc
+
+	// Metrics receives the global limit after every adjustment, if it
+	// implements AdaptiveMetricsCollector like NewMetrics does. Per-key
+	// limits aren't reported.
+	Metrics MetricsCollector
+}
+
+// AdaptiveMetricsCollector is an optional interface for MetricsCollectors
+// reporting adaptive limits (see AdaptiveOptions.Metrics).
+type AdaptiveMetricsCollector interface {
+	SetAdaptiveLimit(name string, limit int)
+}
+
+// NewAdaptiveLimit creates a limit adjusting itself to the health of the
+// backend: it shrinks while handlers are slow or failing, and grows back while
+// they are healthy. It is a LimitProvider for the rate limiter enforcing it,
+// and a middleware observing the handlers it protects:
+//
+//	adaptive := httprate.NewAdaptiveLimit(httprate.AdaptiveOptions{
+//		Min:           50,
+//		Max:           1000,
+//		LatencyTarget: 200 * time.Millisecond,
+//	})
+//	r.Use(httprate.LimitBy(1000, time.Second, httprate.Key("*"), httprate.WithLimitProvider(adaptive, 0)))
+//	r.Use(adaptive.Handler)
+//
+// Register the middleware after the rate limiter, so rejected requests aren't
+// observed. The rate limiter's X-RateLimit-Limit header reports the current
+// limit. Don't cache the LimitProvider (use a zero cacheTTL).
+func NewAdaptiveLimit(opts AdaptiveOptions) *AdaptiveLimit {
+	if opts.Min < 1 {
+		opts.Min = 1
+	}
+	if opts.Max < opts.Min {
+		opts.Max = opts.Min
+	}
+	if opts.Initial == 0 {
+		opts.Initial = opts.Max
+	}
+	opts.Initial = min(max(opts.Initial, opts.Min), opts.Max)
+	if opts.MaxErrorRate <= 0 {
+		opts.MaxErrorRate = 0.1
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Increase <= 0 {
+		opts.Increase = max(opts.Max/100, 1)
+	}
+	if opts.DecreaseFactor <= 0 || opts.DecreaseFactor >= 1 {
+		opts.DecreaseFactor = 0.9
+	}
+
+	a := &AdaptiveLimit{
+		opts:   opts,
+		states: make(map[string]*adaptiveState),
+		next:   time.Now().Add(opts.Interval),
+	}
+	if m, ok := opts.Metrics.(AdaptiveMetricsCollector); ok {
+		a.metrics = m
+		m.SetAdaptiveLimit(opts.Name, opts.Initial)
+	}
+	return a
+}
+
+// AdaptiveLimit is the limit returned by NewAdaptiveLimit.
+type AdaptiveLimit struct {
+	opts    AdaptiveOptions
+	metrics AdaptiveMetricsCollector
+
+	states map[string]*adaptiveState // by key, "" for the global limit
+	next   time.Time                 // next adjustment
+	mu     sync.Mutex
+}
+
+type adaptiveState struct {
+	limit    int
+	requests int
+	errors   int
+	latency  time.Duration // sum over requests
+}
+
+var _ LimitProvider = (*AdaptiveLimit)(nil)
+
+// KeyLimit implements LimitProvider, returning the current limit of key.
+func (a *AdaptiveLimit) KeyLimit(ctx context.Context, key string) (KeyLimit, error) {
+	return KeyLimit{Limit: a.Limit(key)}, nil
+}
+
+// Limit returns the current limit of key, or the global limit without a
+// KeyFunc.
+func (a *AdaptiveLimit) Limit(key string) int {
+	if a.opts.KeyFunc == nil {
+		key = ""
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.adjust(time.Now())
+	if s, ok := a.states[key]; ok {
+		return s.limit
+	}
+	return a.opts.Initial
+}
+
+// Handler is a middleware observing the latency and status of requests.
+func (a *AdaptiveLimit) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if a.opts.KeyFunc != nil {
+			var err error
+			if key, err = a.opts.KeyFunc(r); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Match the keys given to the LimitProvider.
+			key = strings.TrimSuffix(key, ":")
+		}
+
+		ww, sw := newStatusWriter(w)
+		start := time.Now()
+		defer func() {
+			a.Observe(key, time.Since(start), sw.status)
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// Observe records a request to key that took latency and responded with
+// status, for handlers not wrapped with Handler.
+func (a *AdaptiveLimit) Observe(key string, latency time.Duration, status int) {
+	if a.opts.KeyFunc == nil {
+		key = ""
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.adjust(now)
+	s, ok := a.states[key]
+	if !ok {
+		s = &adaptiveState{limit: a.opts.Initial}
+		a.states[key] = s
+	}
+	s.requests++
+	s.latency += latency
+	if status >= 500 {
+		s.errors++
+	}
+}
+
+// adjust adjusts the limits from the requests observed during the last
+// interval, if it is over. Keys idle for an interval recover additively and
+// are forgotten once back at the initial limit.
+func (a *AdaptiveLimit) adjust(now time.Time) {
+	if now.Before(a.next) {
+		return
+	}
+	a.next = now.Add(a.opts.Interval)
+
+	for key, s := range a.states {
+		switch {
+		case s.requests == 0:
+			s.limit = min(s.limit+a.opts.Increase, a.opts.Max)
+			if s.limit >= a.opts.Initial {
+				delete(a.states, key)
+			}
+		case a.degraded(s):
+			s.limit = max(int(float64(s.limit)*a.opts.DecreaseFactor), a.opts.Min)
+		default:
+			s.limit = min(s.limit+a.opts.Increase, a.opts.Max)
+		}
+		s.requests, s.errors, s.latency = 0, 0, 0
+	}
+
+	if a.metrics != nil && a.opts.KeyFunc == nil {
+		limit := a.opts.Initial
+		if s, ok := a.states[""]; ok {
+			limit = s.limit
+		}
+		a.metrics.SetAdaptiveLimit(a.opts.Name, limit)
+	}
+}
+
+func (a *AdaptiveLimit) degraded(s *adaptiveState) bool {
+	if float64(s.errors)/float64(s.requests) > a.opts.MaxErrorRate {
+		return true
+	}
+	return a.opts.LatencyTarget > 0 && s.latency/time.Duration(s.requests) > a.opts.LatencyTarget
+}
+
+// newStatusWriter wraps w to record the status code of the response. The
+// wrapper keeps the optional interfaces of w handlers commonly rely on, e.g.
+// http.Flusher to stream responses or http.Hijacker for WebSockets.
+func newStatusWriter(w http.ResponseWriter) (http.ResponseWriter, *statusWriter) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+	_, fl := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+	_, rf := w.(io.ReaderFrom)
+	_, ps := w.(http.Pusher)
+	switch {
+	case fl && hj && rf:
+		return &http1StatusWriter{sw}, sw
+	case fl && ps:
+		return &http2StatusWriter{sw}, sw
+	case fl:
+		return &flushStatusWriter{sw}, sw
+	}
+	return sw, sw
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flushStatusWriter is a statusWriter of an http.Flusher.
+type flushStatusWriter struct {
+	*statusWriter
+}
+
+func (w *flushStatusWriter) Flush() {
+	w.wroteHeader = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// http1StatusWriter is a statusWriter of an HTTP/1.x response.
+type http1StatusWriter struct {
+	*statusWriter
+}
+
+func (w *http1StatusWriter) Flush() {
+	w.wroteHeader = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *http1StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w *http1StatusWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+}
+
+// http2StatusWriter is a statusWriter of an HTTP/2 response.
+type http2StatusWriter struct {
+	*statusWriter
+}
+
+func (w *http2StatusWriter) Flush() {
+	w.wroteHeader = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *http2StatusWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
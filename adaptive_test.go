@@ -0,0 +1,126 @@
+package httprate_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestAdaptiveLimitAIMD(t *testing.T) {
+	metrics := httprate.NewMetrics()
+	a := httprate.NewAdaptiveLimit(httprate.AdaptiveOptions{
+		Name:          "api",
+		Min:           10,
+		Max:           100,
+		LatencyTarget: 10 * time.Millisecond,
+		Interval:      20 * time.Millisecond,
+		Metrics:       metrics,
+	})
+	if got := a.Limit(""); got != 100 {
+		t.Fatalf("initial Limit = %v, want Max", got)
+	}
+
+	step := func(latency time.Duration, status int) int {
+		for range 5 {
+			a.Observe("", latency, status)
+		}
+		time.Sleep(25 * time.Millisecond)
+		return a.Limit("")
+	}
+
+	if got := step(50*time.Millisecond, http.StatusOK); got != 90 {
+		t.Errorf("after slow requests: Limit = %v, want 90", got)
+	}
+	if got := step(time.Millisecond, http.StatusBadGateway); got != 81 {
+		t.Errorf("after errors: Limit = %v, want 81", got)
+	}
+	if got := step(time.Millisecond, http.StatusOK); got != 82 {
+		t.Errorf("after healthy requests: Limit = %v, want 82", got)
+	}
+	if want := `httprate_adaptive_limit{limiter="api"} 82`; !strings.Contains(metrics.String(), want) {
+		t.Errorf("metrics missing %q", want)
+	}
+
+	for range 25 {
+		step(time.Second, http.StatusOK)
+	}
+	if got := a.Limit(""); got != 10 {
+		t.Errorf("after sustained degradation: Limit = %v, want Min", got)
+	}
+}
+
+func TestAdaptiveLimitPerKey(t *testing.T) {
+	a := httprate.NewAdaptiveLimit(httprate.AdaptiveOptions{
+		Min:      1,
+		Max:      10,
+		Interval: 20 * time.Millisecond,
+		KeyFunc:  httprate.KeyByHeader("X-Tenant"),
+	})
+	limiter := httprate.LimitBy(100, time.Minute, httprate.KeyByHeader("X-Tenant"),
+		httprate.WithLimitProvider(a, 0))
+
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant") == "acme" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	h := limiter(a.Handler(failing))
+
+	do := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	do("acme")
+	do("globex")
+	time.Sleep(25 * time.Millisecond)
+
+	// Only the failing tenant's limit shrinks, as reported in the headers.
+	if got := do("acme").Header().Get("X-RateLimit-Limit"); got != "9" {
+		t.Errorf("acme X-RateLimit-Limit = %q, want 9", got)
+	}
+	if got := do("globex").Header().Get("X-RateLimit-Limit"); got != "10" {
+		t.Errorf("globex X-RateLimit-Limit = %q, want 10", got)
+	}
+}
+
+func TestAdaptiveLimitResponseWriter(t *testing.T) {
+	a := httprate.NewAdaptiveLimit(httprate.AdaptiveOptions{Min: 1, Max: 10})
+
+	// The wrapped writer keeps the interfaces of a net/http server's.
+	var flusher, hijacker, readerFrom bool
+	server := httptest.NewServer(a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+		_, readerFrom = w.(io.ReaderFrom)
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || !flusher || !hijacker || !readerFrom {
+		t.Errorf("status %v, Flusher %v, Hijacker %v, ReaderFrom %v: want 202 and all true", resp.StatusCode, flusher, hijacker, readerFrom)
+	}
+
+	// And doesn't add any the underlying writer lacks.
+	a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !flusher || hijacker {
+		t.Errorf("recorder: Flusher %v, Hijacker %v, want true false", flusher, hijacker)
+	}
+}
@@ -0,0 +1,276 @@
+package httprate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewAdminHandler creates an http.Handler for operators to inspect and reset
+// the state of registered limiters, e.g. to answer "why is customer X being
+// throttled?". All responses are JSON:
+//
+//	GET    /limiters                    list registered limiters
+//	GET    /limiters/{name}/keys/{key}  status of a key
+//	DELETE /limiters/{name}/keys/{key}  reset a key
+//	GET    /limiters/{name}/top?n=10    heaviest keys in the current window
+//	GET    /quotas/{name}/keys/{key}    quota usage of a key, see RegisterQuota
+//
+// With a heavy-hitter tracker (see WithHeavyHitters), the top endpoint also
+// accepts by=rejections to list the keys with the most rejected requests.
+//
+// Every request must pass authorize, or it is rejected with 403 Forbidden; a
+// nil authorize rejects everything. The handler serves paths relative to where
+// it is mounted, so strip the mount prefix:
+//
+//	admin := httprate.NewAdminHandler(isOperator)
+//	admin.Register(loginLimiter, apiLimiter)
+//	r.Mount("/admin/ratelimit", http.StripPrefix("/admin/ratelimit", admin))
+func NewAdminHandler(authorize func(r *http.Request) bool) *AdminHandler {
+	h := &AdminHandler{
+		authorize: authorize,
+		limiters:  make(map[string]*RateLimiter),
+		quotas:    make(map[string]*Quota),
+		mux:       http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /limiters", h.list)
+	h.mux.HandleFunc("GET /limiters/{name}/keys/{key...}", h.status)
+	h.mux.HandleFunc("DELETE /limiters/{name}/keys/{key...}", h.reset)
+	h.mux.HandleFunc("GET /limiters/{name}/top", h.top)
+	h.mux.HandleFunc("GET /quotas/{name}/keys/{key...}", h.quotaUsage)
+	return h
+}
+
+// AdminHandler is the http.Handler returned by NewAdminHandler.
+type AdminHandler struct {
+	authorize func(r *http.Request) bool
+	limiters  map[string]*RateLimiter
+	quotas    map[string]*Quota
+	mux       *http.ServeMux
+	mu        sync.RWMutex
+}
+
+// Register makes limiters available through the handler, by name (see
+// WithName). It returns an error if a limiter has no name or its name is
+// already registered.
+func (h *AdminHandler) Register(limiters ...*RateLimiter) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, l := range limiters {
+		if l.name == "" {
+			return errors.New("httprate: admin handler requires named limiters, see WithName")
+		}
+		if _, ok := h.limiters[l.name]; ok {
+			return fmt.Errorf("httprate: limiter %q already registered", l.name)
+		}
+		h.limiters[l.name] = l
+	}
+	return nil
+}
+
+// RegisterQuota makes quotas available through the handler, by name (see
+// QuotaOptions.Name). The usage endpoint accepts at=<RFC 3339 time> to query a
+// past period, e.g. last month's for billing.
+func (h *AdminHandler) RegisterQuota(quotas ...*Quota) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, q := range quotas {
+		if q.Name() == "" {
+			return errors.New("httprate: admin handler requires named quotas, see QuotaOptions.Name")
+		}
+		if _, ok := h.quotas[q.Name()]; ok {
+			return fmt.Errorf("httprate: quota %q already registered", q.Name())
+		}
+		h.quotas[q.Name()] = q
+	}
+	return nil
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorize == nil || !h.authorize(r) {
+		writeJSONError(w, http.StatusForbidden, errors.New("forbidden"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+type adminLimiter struct {
+	Name   string `json:"name"`
+	Limit  int    `json:"limit"`
+	Window string `json:"window"`
+}
+
+type adminKeyStatus struct {
+	Limiter   string    `json:"limiter"`
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Rate      float64   `json:"rate"`
+	Remaining int       `json:"remaining"`
+	Limited   bool      `json:"limited"`
+	Reset     time.Time `json:"reset"`
+}
+
+func (h *AdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	limiters := make([]adminLimiter, 0, len(h.limiters))
+	for _, l := range h.limiters {
+		limiters = append(limiters, adminLimiter{
+			Name:   l.name,
+			Limit:  l.Limit(),
+			Window: l.WindowLength().String(),
+		})
+	}
+	h.mu.RUnlock()
+
+	slices.SortFunc(limiters, func(a, b adminLimiter) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, limiters)
+}
+
+func (h *AdminHandler) status(w http.ResponseWriter, r *http.Request) {
+	l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	b, err := l.status(context.Background(), key)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminKeyStatus{
+		Limiter:   l.name,
+		Key:       key,
+		Limit:     b.limit,
+		Rate:      b.rate,
+		Remaining: max(b.limit-int(math.Round(b.rate)), 0),
+		Limited:   b.rate > float64(b.limit),
+		Reset:     b.reset,
+	})
+}
+
+func (h *AdminHandler) reset(w http.ResponseWriter, r *http.Request) {
+	l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	if err := l.Reset(r.PathValue("key")); err != nil {
+		writeJSONError(w, statusForError(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) top(w http.ResponseWriter, r *http.Request) {
+	l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid n %q", v))
+			return
+		}
+	}
+
+	switch by := r.URL.Query().Get("by"); by {
+	case "", "requests":
+	case "rejections":
+		if l.heavyHitters == nil {
+			writeJSONError(w, http.StatusNotImplemented, fmt.Errorf("limiter %q does not track rejections, see WithHeavyHitters", l.name))
+			return
+		}
+		top := l.heavyHitters.TopRejections(n)
+		for i := range top {
+			top[i].Key = l.originalKey(top[i].Key)
+		}
+		writeJSON(w, http.StatusOK, top)
+		return
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid by %q", by))
+		return
+	}
+
+	keys, err := l.TopKeys(n)
+	if err != nil {
+		writeJSONError(w, statusForError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (h *AdminHandler) quotaUsage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	h.mu.RLock()
+	q, ok := h.quotas[name]
+	h.mu.RUnlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("quota %q not found", name))
+		return
+	}
+
+	at := time.Now()
+	if v := r.URL.Query().Get("at"); v != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid at %q", v))
+			return
+		}
+	}
+
+	usage, err := q.Usage(r.Context(), r.PathValue("key"), at)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// limiter looks up the limiter named in the request path, responding with 404
+// Not Found if there is none.
+func (h *AdminHandler) limiter(w http.ResponseWriter, r *http.Request) (*RateLimiter, bool) {
+	name := r.PathValue("name")
+
+	h.mu.RLock()
+	l, ok := h.limiters[name]
+	h.mu.RUnlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("limiter %q not found", name))
+	}
+	return l, ok
+}
+
+func statusForError(err error) int {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
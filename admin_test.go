@@ -0,0 +1,116 @@
+package httprate_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestAdminHandler(t *testing.T) {
+	login := httprate.NewRateLimiter(2, time.Minute, httprate.WithName("login"))
+	search := httprate.NewRateLimiter(100, time.Second, httprate.WithName("search"))
+
+	admin := httprate.NewAdminHandler(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer operator"
+	})
+	if err := admin.Register(login, search); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Register(httprate.NewRateLimiter(1, time.Second)); err == nil {
+		t.Error("Register(unnamed limiter): want error")
+	}
+	if err := admin.Register(httprate.NewRateLimiter(1, time.Second, httprate.WithName("login"))); err == nil {
+		t.Error("Register(duplicate name): want error")
+	}
+
+	h := http.StripPrefix("/admin/ratelimit", admin)
+	do := func(method, path string, authorized bool) *http.Response {
+		req := httptest.NewRequest(method, "/admin/ratelimit"+path, nil)
+		if authorized {
+			req.Header.Set("Authorization", "Bearer operator")
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	for range 2 {
+		login.OnLimit(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), "alice@example.com")
+	}
+
+	if resp := do("GET", "/limiters", false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unauthorized: StatusCode = %v, want 403", resp.StatusCode)
+	}
+
+	resp := do("GET", "/limiters", true)
+	var limiters []struct {
+		Name   string
+		Limit  int
+		Window string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&limiters); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiters) != 2 || limiters[0].Name != "login" || limiters[0].Limit != 2 || limiters[0].Window != "1m0s" || limiters[1].Name != "search" {
+		t.Errorf("limiters = %+v", limiters)
+	}
+
+	status := func() (rate float64, limited bool) {
+		resp := do("GET", "/limiters/login/keys/alice@example.com", true)
+		var s struct {
+			Key     string
+			Rate    float64
+			Limited bool
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Key != "alice@example.com" {
+			t.Errorf("status key = %q", s.Key)
+		}
+		return s.Rate, s.Limited
+	}
+
+	if rate, _ := status(); rate != 2 {
+		t.Errorf("status rate = %v, want 2", rate)
+	}
+
+	if resp := do("DELETE", "/limiters/login/keys/alice@example.com", true); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("reset: StatusCode = %v, want 204", resp.StatusCode)
+	}
+	if rate, limited := status(); rate != 0 || limited {
+		t.Errorf("after reset: rate = %v limited = %v, want 0 false", rate, limited)
+	}
+
+	// The local counter can't list its keys.
+	if resp := do("GET", "/limiters/login/top", true); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("top: StatusCode = %v, want 501", resp.StatusCode)
+	}
+
+	// Heavy hitters can.
+	tracked := httprate.NewRateLimiter(2, time.Minute, httprate.WithName("tracked"),
+		httprate.WithHeavyHitters(httprate.NewHeavyHitters(10)))
+	if err := admin.Register(tracked); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"bob@example.com", "carol@example.com", "bob@example.com"} {
+		tracked.OnLimit(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), key)
+	}
+	resp = do("GET", "/limiters/tracked/top?n=2", true)
+	var top []httprate.KeyCount
+	if err := json.NewDecoder(resp.Body).Decode(&top); err != nil {
+		t.Fatal(err)
+	}
+	want := []httprate.KeyCount{{Key: "bob@example.com", Count: 2}, {Key: "carol@example.com", Count: 1}}
+	if !slices.Equal(top, want) {
+		t.Errorf("top = %+v, want %+v", top, want)
+	}
+	if resp := do("GET", "/limiters/nope/keys/x", true); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown limiter: StatusCode = %v, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package httprate
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPCanonicalizer normalizes client IPs for use as rate-limit keys, like
+// CanonicalizeIP, but with configurable prefix lengths so clients rotating
+// addresses within a block share one bucket:
+//
+//   - IPv4 addresses are reduced to their IPv4Prefix, e.g. a /24 for abusers
+//     rotating within a subnet.
+//   - IPv6 addresses are reduced to their IPv6Prefix, e.g. a /56 or /48 for
+//     clients delegated a larger block than a /64.
+//   - IPv4-mapped IPv6 addresses (::ffff:1.2.3.4) are treated as IPv4, so a
+//     client gets the same bucket on a dual-stack listener.
+//   - IPv6 zones (fe80::1%eth0) are stripped.
+//   - Any other string, including "", is returned unchanged.
+//
+// The result is the masked address, e.g. "203.0.113.0" or "2001:db8:ab00::".
+type IPCanonicalizer struct {
+	// IPv4Prefix is the prefix length IPv4 addresses are reduced to, from 1 to 32.
+	// Default: 32 (the address is kept as is)
+	IPv4Prefix int
+
+	// IPv6Prefix is the prefix length IPv6 addresses are reduced to, from 1 to 128.
+	// Default: 64
+	IPv6Prefix int
+}
+
+// Canonicalize returns the canonical form of ip.
+func (c IPCanonicalizer) Canonicalize(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.WithZone("").Unmap()
+
+	bits := c.IPv6Prefix
+	if bits <= 0 || bits > 128 {
+		bits = 64
+	}
+	if addr.Is4() {
+		bits = c.IPv4Prefix
+		if bits <= 0 || bits > 32 {
+			bits = 32
+		}
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.Addr().String()
+}
+
+// KeyFunc returns a KeyFunc keying requests by the canonical form of the
+// client IP returned by ipFn. httprate does not resolve the client IP itself;
+// see CanonicalizeIP for how to do it with chi's middleware.ClientIPFrom*:
+//
+//	canonicalizer := httprate.IPCanonicalizer{IPv4Prefix: 24, IPv6Prefix: 56}
+//	r.Use(middleware.ClientIPFromXFF("10.0.0.0/8"))
+//	r.Use(httprate.LimitBy(100, time.Minute, canonicalizer.KeyFunc(func(r *http.Request) string {
+//		return middleware.GetClientIP(r.Context())
+//	})))
+//
+// The same caveat as CanonicalizeIP applies: if ipFn returns "", every request
+// shares a single rate-limit bucket.
+func (c IPCanonicalizer) KeyFunc(ipFn func(r *http.Request) string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return c.Canonicalize(strings.TrimSpace(ipFn(r))), nil
+	}
+}
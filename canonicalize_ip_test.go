@@ -0,0 +1,93 @@
+package httprate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestIPCanonicalizer(t *testing.T) {
+	tests := []struct {
+		name          string
+		canonicalizer httprate.IPCanonicalizer
+		ip            string
+		want          string
+	}{
+		{
+			name: "defaults: IPv4 unchanged",
+			ip:   "1.2.3.4",
+			want: "1.2.3.4",
+		},
+		{
+			name: "defaults: IPv6 /64",
+			ip:   "2001:0db8:85a3:0000:0000:8a2e:0370:7334",
+			want: "2001:db8:85a3::",
+		},
+		{
+			name:          "IPv4 /24",
+			canonicalizer: httprate.IPCanonicalizer{IPv4Prefix: 24},
+			ip:            "203.0.113.77",
+			want:          "203.0.113.0",
+		},
+		{
+			name:          "IPv6 /56",
+			canonicalizer: httprate.IPCanonicalizer{IPv6Prefix: 56},
+			ip:            "2001:db8:abcd:12ff:1:2:3:4",
+			want:          "2001:db8:abcd:1200::",
+		},
+		{
+			name:          "IPv6 /48",
+			canonicalizer: httprate.IPCanonicalizer{IPv6Prefix: 48},
+			ip:            "2001:db8:abcd:12ff:1:2:3:4",
+			want:          "2001:db8:abcd::",
+		},
+		{
+			name:          "IPv4-mapped IPv6 treated as IPv4",
+			canonicalizer: httprate.IPCanonicalizer{IPv4Prefix: 24},
+			ip:            "::ffff:203.0.113.77",
+			want:          "203.0.113.0",
+		},
+		{
+			name: "zone stripped",
+			ip:   "fe80::1ff:fe23:4567:890a%eth0",
+			want: "fe80::",
+		},
+		{
+			name:          "out of range prefixes fall back to defaults",
+			canonicalizer: httprate.IPCanonicalizer{IPv4Prefix: 33, IPv6Prefix: -1},
+			ip:            "1.2.3.4",
+			want:          "1.2.3.4",
+		},
+		{
+			name: "bad IP unchanged",
+			ip:   "not an IP",
+			want: "not an IP",
+		},
+		{
+			name: "empty string unchanged",
+			ip:   "",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.canonicalizer.Canonicalize(tt.ip); got != tt.want {
+				t.Errorf("Canonicalize(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPCanonicalizerKeyFunc(t *testing.T) {
+	keyFn := httprate.IPCanonicalizer{IPv4Prefix: 24}.KeyFunc(func(r *http.Request) string {
+		return r.Header.Get("X-Test-IP")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Test-IP", "198.51.100.23")
+	if key, err := keyFn(req); err != nil || key != "198.51.100.0" {
+		t.Errorf("KeyFunc() = %q, %v, want 198.51.100.0", key, err)
+	}
+}
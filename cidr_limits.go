@@ -0,0 +1,189 @@
+package httprate
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// NewCIDRLimits creates a table of per-network limit overrides and exemptions,
+// e.g. higher limits for partner networks and office ranges, lower ones for
+// known-abusive ranges. The client IP of each request is read with ipFn;
+// httprate does not resolve it itself (see CanonicalizeIP).
+//
+// Networks are matched by longest prefix, so a more specific range overrides
+// a broader one:
+//
+//	cidrs := httprate.NewCIDRLimits(func(r *http.Request) string {
+//		return middleware.GetClientIP(r.Context())
+//	})
+//	cidrs.Limit("203.0.113.0/24", 10000) // partner
+//	cidrs.Limit("198.51.100.0/24", 10)   // abusive
+//	cidrs.Exempt("10.0.0.0/8")           // office
+//
+//	r.Use(middleware.ClientIPFromXFF("10.0.0.0/8"))
+//	r.Use(cidrs.Handler)
+//	r.Use(httprate.LimitBy(100, time.Minute, clientIPKey))
+func NewCIDRLimits(ipFn func(r *http.Request) string) *CIDRLimits {
+	return &CIDRLimits{ipFn: ipFn}
+}
+
+// CIDRLimits is the table returned by NewCIDRLimits. It is safe for
+// concurrent use, including adding networks while serving requests.
+type CIDRLimits struct {
+	ipFn func(r *http.Request) string
+	tree cidrTree
+	mu   sync.RWMutex
+}
+
+// cidrTree is a binary radix tree of networks, matched by longest prefix.
+// The zero value is an empty tree.
+type cidrTree struct {
+	v4 cidrNode
+	v6 cidrNode
+}
+
+// cidrNode is a node of a cidrTree keyed by address bits.
+type cidrNode struct {
+	children [2]*cidrNode
+	entry    *cidrEntry
+}
+
+type cidrEntry struct {
+	limit  int
+	exempt bool
+}
+
+// Limit overrides the request limit for clients in cidr, e.g. "10.0.0.0/8" or
+// "2001:db8::/32". A bare IP address is treated as a single-address network.
+func (c *CIDRLimits) Limit(cidr string, limit int) error {
+	if limit <= 0 {
+		return fmt.Errorf("httprate: invalid limit %d for %q", limit, cidr)
+	}
+	return c.insert(cidr, &cidrEntry{limit: limit})
+}
+
+// Exempt exempts clients in cidr from rate-limiting altogether.
+func (c *CIDRLimits) Exempt(cidr string) error {
+	return c.insert(cidr, &cidrEntry{exempt: true})
+}
+
+// Lookup returns the override for ip, from the longest matching network. ok is
+// false if no network matches.
+func (c *CIDRLimits) Lookup(ip string) (limit int, exempt bool, ok bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, false, false
+	}
+	addr = addr.WithZone("").Unmap()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	match := c.tree.lookup(addr)
+	if match == nil {
+		return 0, false, false
+	}
+	return match.limit, match.exempt, true
+}
+
+// Handler is a middleware applying the override for the client IP to every
+// rate limiter downstream: the request limit is overridden (see
+// WithRequestLimit), or the request is exempted from rate-limiting.
+func (c *CIDRLimits) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, exempt, ok := c.Lookup(strings.TrimSpace(c.ipFn(r)))
+		if ok {
+			if exempt {
+				r = r.WithContext(withExempt(r.Context()))
+			} else {
+				r = r.WithContext(WithRequestLimit(r.Context(), limit))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *CIDRLimits) insert(cidr string, entry *cidrEntry) error {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tree.insert(prefix, entry)
+	return nil
+}
+
+func (t *cidrTree) insert(prefix netip.Prefix, entry *cidrEntry) {
+	node := &t.v6
+	if prefix.Addr().Is4() {
+		node = &t.v4
+	}
+
+	bytes := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		b := bit(bytes, i)
+		if node.children[b] == nil {
+			node.children[b] = &cidrNode{}
+		}
+		node = node.children[b]
+	}
+	node.entry = entry
+}
+
+// lookup returns the entry of the longest network containing addr, or nil.
+// addr must be unmapped and have no zone.
+func (t *cidrTree) lookup(addr netip.Addr) *cidrEntry {
+	node := &t.v6
+	if addr.Is4() {
+		node = &t.v4
+	}
+
+	var match *cidrEntry
+	bytes := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.entry != nil {
+			match = node.entry
+		}
+		if i == len(bytes)*8 {
+			break
+		}
+		node = node.children[bit(bytes, i)]
+	}
+	return match
+}
+
+// parsePrefix parses a CIDR or a bare IP, unmapping IPv4-mapped IPv6 networks
+// so they match IPv4 clients.
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("httprate: invalid CIDR %q: %w", cidr, err)
+		}
+		addr = addr.WithZone("").Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("httprate: invalid CIDR %q: %w", cidr, err)
+	}
+	if addr := prefix.Addr(); addr.Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("httprate: invalid CIDR %q: IPv4-mapped prefix shorter than /96", cidr)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// bit returns the i-th most significant bit of b.
+func bit(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
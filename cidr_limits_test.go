@@ -0,0 +1,80 @@
+package httprate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestCIDRLimitsLookup(t *testing.T) {
+	cidrs := httprate.NewCIDRLimits(nil)
+	for cidr, limit := range map[string]int{
+		"10.0.0.0/8":      100,
+		"10.1.0.0/16":     200,
+		"10.1.2.3":        300,
+		"2001:db8::/32":   400,
+		"2001:db8:1::/48": 500,
+	} {
+		if err := cidrs.Limit(cidr, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cidrs.Exempt("10.1.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cidrs.Limit("not a cidr", 1); err == nil {
+		t.Error("Limit(invalid CIDR): want error")
+	}
+
+	tests := []struct {
+		ip     string
+		limit  int
+		exempt bool
+		ok     bool
+	}{
+		{ip: "10.9.9.9", limit: 100, ok: true},
+		{ip: "10.1.9.9", limit: 200, ok: true},
+		{ip: "10.1.2.4", exempt: true, ok: true},
+		{ip: "10.1.2.3", limit: 300, ok: true}, // longest prefix wins
+		{ip: "::ffff:10.1.9.9", limit: 200, ok: true},
+		{ip: "11.0.0.1"},
+		{ip: "2001:db8:2::1", limit: 400, ok: true},
+		{ip: "2001:db8:1::1", limit: 500, ok: true},
+		{ip: "2001:db9::1"},
+		{ip: ""},
+	}
+	for _, tt := range tests {
+		limit, exempt, ok := cidrs.Lookup(tt.ip)
+		if limit != tt.limit || exempt != tt.exempt || ok != tt.ok {
+			t.Errorf("Lookup(%q) = %v, %v, %v, want %v, %v, %v", tt.ip, limit, exempt, ok, tt.limit, tt.exempt, tt.ok)
+		}
+	}
+}
+
+func TestCIDRLimitsHandler(t *testing.T) {
+	cidrs := httprate.NewCIDRLimits(func(r *http.Request) string {
+		return r.Header.Get("X-Test-IP")
+	})
+	cidrs.Limit("203.0.113.0/24", 3)
+	cidrs.Exempt("10.0.0.0/8")
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := cidrs.Handler(httprate.LimitBy(1, time.Minute, func(r *http.Request) (string, error) {
+		return r.Header.Get("X-Test-IP"), nil
+	})(h))
+
+	get := func(ip string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Test-IP", ip)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Result().StatusCode
+	}
+
+	wantCodes(t, []int{get("203.0.113.5"), get("203.0.113.5"), get("203.0.113.5"), get("203.0.113.5")}, []int{200, 200, 200, 429})
+	wantCodes(t, []int{get("10.0.0.1"), get("10.0.0.1"), get("10.0.0.1")}, []int{200, 200, 200})
+	wantCodes(t, []int{get("192.0.2.1"), get("192.0.2.1")}, []int{200, 429})
+}
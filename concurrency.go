@@ -0,0 +1,363 @@
+package httprate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is reported to ConcurrencyOptions.OnLeaseLost when the lease of
+// a running request could not be extended, e.g. because it expired meanwhile
+// and its slot was taken by another request.
+var ErrLeaseLost = errors.New("httprate: concurrency lease lost")
+
+// ConcurrencyCounter holds the slots of a ConcurrencyLimiter, as leases on a
+// key. It must be safe for concurrent use, and shared by all instances
+// enforcing the same limit, e.g. Redis sorted sets of lease IDs scored by
+// expiry.
+type ConcurrencyCounter interface {
+	// Acquire takes a lease on a slot for key, identified by lease, if fewer
+	// than limit leases are held. It returns the number of leases held,
+	// including the new one if it was taken. Leases expire after ttl unless
+	// acquired again or released, so instances crashing mid-request don't leak
+	// slots; acquiring a lease already held extends it and always succeeds.
+	Acquire(ctx context.Context, key, lease string, limit int, ttl time.Duration) (inFlight int, ok bool, err error)
+
+	// Release frees the slot held by lease.
+	Release(ctx context.Context, key, lease string) error
+}
+
+// ConcurrencyOptions configures a ConcurrencyLimiter (see
+// NewConcurrencyLimiter).
+type ConcurrencyOptions struct {
+	// Name identifies the limiter in the X-RateLimit-Policy header.
+	Name string
+
+	// KeyFunc keys requests, e.g. by tenant.
+	// Default: a single limit for all requests
+	KeyFunc KeyFunc
+
+	// MaxWait is how long a request over the limit waits for a slot before
+	// being rejected, unless its context is canceled first, and MaxQueue how
+	// many requests may wait per key; more are rejected right away. Zero
+	// MaxWait rejects right away, zero MaxQueue queues without bound.
+	MaxWait  time.Duration
+	MaxQueue int
+
+	// Counter holds the slots, e.g. in a store shared by all instances.
+	// Default: an in-memory counter (see NewLocalConcurrencyCounter)
+	Counter ConcurrencyCounter
+
+	// LeaseTTL is how long a slot stays held by a request that didn't release
+	// it, e.g. because its instance crashed. Leases of running requests are
+	// extended every LeaseTTL/2, however long they take.
+	// Default: 1 minute
+	LeaseTTL time.Duration
+
+	// PollInterval is how often a waiting request retries to take a slot, in
+	// case it was released by another instance. Slots released by this
+	// instance wake waiting requests right away.
+	// Default: 10ms
+	PollInterval time.Duration
+
+	// Headers are the response headers; only Limit, Remaining, RetryAfter and
+	// Policy are set. An empty name omits the header.
+	// Default: the same as the rate limiter's (see WithResponseHeaders)
+	Headers *ResponseHeaders
+
+	// OnLimited responds to requests rejected for lack of a slot.
+	// Default: 429 Too Many Requests
+	OnLimited http.HandlerFunc
+
+	// OnError responds to requests whose key or slot could not be resolved.
+	// Default: the same as the rate limiter's (see WithErrorHandler)
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+
+	// OnLeaseLost is called when the lease of a running request fails to be
+	// extended, with ErrLeaseLost or the counter's error; the request holds no
+	// slot from then on, so more than the limit may be in flight. It is
+	// called from another goroutine and can't respond, as the request is
+	// already being handled.
+	// Default: none
+	OnLeaseLost func(r *http.Request, key string, err error)
+}
+
+// NewConcurrencyLimiter creates a limiter of in-flight requests, e.g. at most
+// 5 concurrent requests per tenant, protecting backends from slow requests
+// piling up where a rate limit can't:
+//
+//	r.Use(httprate.NewConcurrencyLimiter(5, httprate.ConcurrencyOptions{
+//		KeyFunc: tenantKey,
+//		MaxWait: 2 * time.Second, // queue for up to 2s before rejecting
+//	}).Handler)
+//
+// A request takes a slot before calling the next handler and releases it once
+// the handler returns. It panics if limit isn't positive.
+func NewConcurrencyLimiter(limit int, opts ConcurrencyOptions) *ConcurrencyLimiter {
+	if limit <= 0 {
+		panic("httprate: concurrency limit must be positive")
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = Key("*")
+	}
+	if opts.Counter == nil {
+		opts.Counter = NewLocalConcurrencyCounter()
+	}
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = time.Minute
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Millisecond
+	}
+	if opts.Headers == nil {
+		opts.Headers = &defaultResponseHeaders
+	}
+	if opts.OnLimited == nil {
+		opts.OnLimited = onRateLimited
+	}
+	if opts.OnError == nil {
+		opts.OnError = onError
+	}
+	return &ConcurrencyLimiter{
+		limit:    limit,
+		opts:     opts,
+		waiting:  make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// ConcurrencyLimiter is the middleware returned by NewConcurrencyLimiter.
+type ConcurrencyLimiter struct {
+	limit int
+	opts  ConcurrencyOptions
+
+	waiting  map[string]int // requests waiting for a slot, by key
+	released chan struct{}  // closed when a slot is released, see acquire
+	mu       sync.Mutex
+}
+
+// Limit returns the maximum number of in-flight requests per key.
+func (l *ConcurrencyLimiter) Limit() int {
+	return l.limit
+}
+
+// Handler is a middleware limiting in-flight requests.
+func (l *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := l.opts.KeyFunc(r)
+		if err != nil {
+			l.opts.OnError(w, r, err)
+			return
+		}
+
+		release, ok := l.Acquire(w, r, key)
+		if !ok {
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Acquire takes a slot for key, waiting up to MaxWait, and sets the response
+// headers. If it returns true, the caller must call release once done with
+// the request. Otherwise, it has responded and the caller must halt the
+// request.
+func (l *ConcurrencyLimiter) Acquire(w http.ResponseWriter, r *http.Request, key string) (release func(), ok bool) {
+	ctx := r.Context()
+	headers := l.opts.Headers
+	lease := newLeaseID()
+
+	setHeader(w, headers.Limit, strconv.Itoa(l.limit))
+	if l.opts.Name != "" {
+		setHeader(w, headers.Policy, l.opts.Name)
+	}
+
+	inFlight, ok, err := l.acquire(ctx, key, lease)
+	if err != nil {
+		l.opts.OnError(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		setHeader(w, headers.Remaining, "0")
+		setHeader(w, headers.RetryAfter, "1")
+		l.opts.OnLimited(w, r)
+		return nil, false
+	}
+	setHeader(w, headers.Remaining, strconv.Itoa(max(l.limit-inFlight, 0)))
+
+	// Extend the lease for as long as the request runs. renewMu is held while
+	// renewing, so release waits for a running renewal rather than racing it
+	// and leaving the lease behind.
+	var renew *time.Timer
+	var renewMu sync.Mutex
+	var done bool
+	renewCtx := context.WithoutCancel(ctx)
+	renewMu.Lock()
+	renew = time.AfterFunc(l.opts.LeaseTTL/2, func() {
+		renewMu.Lock()
+		defer renewMu.Unlock()
+		if done {
+			return
+		}
+
+		_, ok, err := l.opts.Counter.Acquire(renewCtx, key, lease, l.limit, l.opts.LeaseTTL)
+		if err == nil && !ok {
+			err = ErrLeaseLost
+		}
+		if err != nil && l.opts.OnLeaseLost != nil {
+			l.opts.OnLeaseLost(r, key, err)
+		}
+		renew.Reset(l.opts.LeaseTTL / 2)
+	})
+	renewMu.Unlock()
+
+	return func() {
+		renewMu.Lock()
+		done = true
+		renew.Stop()
+		renewMu.Unlock()
+
+		// A lease that fails to be released frees its slot once expired.
+		l.opts.Counter.Release(renewCtx, key, lease)
+		l.notify()
+	}, true
+}
+
+// acquire takes a slot, waiting for one to be released if the queue allows.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, key, lease string) (int, bool, error) {
+	l.mu.Lock()
+	released := l.released
+	l.mu.Unlock()
+
+	inFlight, ok, err := l.opts.Counter.Acquire(ctx, key, lease, l.limit, l.opts.LeaseTTL)
+	if err != nil || ok || l.opts.MaxWait <= 0 {
+		return inFlight, ok, err
+	}
+
+	l.mu.Lock()
+	if l.opts.MaxQueue > 0 && l.waiting[key] >= l.opts.MaxQueue {
+		l.mu.Unlock()
+		return inFlight, false, nil
+	}
+	l.waiting[key]++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		if l.waiting[key]--; l.waiting[key] == 0 {
+			delete(l.waiting, key)
+		}
+		l.mu.Unlock()
+	}()
+
+	deadline := time.NewTimer(l.opts.MaxWait)
+	defer deadline.Stop()
+	poll := time.NewTicker(l.opts.PollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return inFlight, false, nil
+		case <-deadline.C:
+			return inFlight, false, nil
+		case <-released:
+		case <-poll.C:
+		}
+
+		l.mu.Lock()
+		released = l.released
+		l.mu.Unlock()
+
+		inFlight, ok, err = l.opts.Counter.Acquire(ctx, key, lease, l.limit, l.opts.LeaseTTL)
+		if err != nil || ok {
+			return inFlight, ok, err
+		}
+	}
+}
+
+// notify wakes the requests waiting for a slot.
+func (l *ConcurrencyLimiter) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func newLeaseID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// NewLocalConcurrencyCounter creates an in-memory ConcurrencyCounter, for a
+// single instance.
+func NewLocalConcurrencyCounter() *LocalConcurrencyCounter {
+	return &LocalConcurrencyCounter{leases: make(map[string]map[string]time.Time)}
+}
+
+// LocalConcurrencyCounter is the ConcurrencyCounter returned by
+// NewLocalConcurrencyCounter.
+type LocalConcurrencyCounter struct {
+	leases map[string]map[string]time.Time // lease expiries, by key and lease
+	mu     sync.Mutex
+}
+
+var _ ConcurrencyCounter = (*LocalConcurrencyCounter)(nil)
+
+func (c *LocalConcurrencyCounter) Acquire(ctx context.Context, key, lease string, limit int, ttl time.Duration) (int, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	leases := c.leases[key]
+	for id, expiry := range leases {
+		if !now.Before(expiry) {
+			delete(leases, id)
+		}
+	}
+
+	if _, held := leases[lease]; !held && len(leases) >= limit {
+		return len(leases), false, nil
+	}
+	if leases == nil {
+		leases = make(map[string]time.Time)
+		c.leases[key] = leases
+	}
+	leases[lease] = now.Add(ttl)
+	return len(leases), true, nil
+}
+
+func (c *LocalConcurrencyCounter) Release(ctx context.Context, key, lease string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.leases[key], lease)
+	if len(c.leases[key]) == 0 {
+		delete(c.leases, key)
+	}
+	return nil
+}
+
+// InFlight returns the number of requests holding a slot for key.
+func (c *LocalConcurrencyCounter) InFlight(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, expiry := range c.leases[key] {
+		if now.Before(expiry) {
+			n++
+		}
+	}
+	return n
+}
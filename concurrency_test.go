@@ -0,0 +1,250 @@
+package httprate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// blockingHandler responds once release is closed, signaling on started when
+// a request enters it.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	l := httprate.NewConcurrencyLimiter(2, httprate.ConcurrencyOptions{
+		KeyFunc: httprate.KeyByHeader("X-Tenant"),
+	})
+	h := l.Handler(blockingHandler(started, release))
+
+	do := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := do("acme").Code; code != http.StatusOK {
+				t.Errorf("in-flight request: StatusCode = %v, want 200", code)
+			}
+		}()
+		<-started
+	}
+
+	resp := do("acme")
+	if resp.Code != http.StatusTooManyRequests {
+		t.Errorf("third request: StatusCode = %v, want 429", resp.Code)
+	}
+	if got := resp.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+	}
+	if got := resp.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Other tenants have their own slots.
+	go func() { <-started }()
+	close(release)
+	if code := do("globex").Code; code != http.StatusOK {
+		t.Errorf("other tenant: StatusCode = %v, want 200", code)
+	}
+
+	// Slots are released once the requests are done.
+	wg.Wait()
+	go func() { <-started }()
+	if resp := do("acme"); resp.Code != http.StatusOK || resp.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("after release: StatusCode = %v, X-RateLimit-Remaining = %q, want 200 and 1", resp.Code, resp.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	l := httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{
+		MaxWait:  time.Second,
+		MaxQueue: 1,
+	})
+	h := l.Handler(blockingHandler(started, release))
+
+	codes := make(chan int, 3)
+	do := func(ctx context.Context) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		codes <- recorder.Code
+	}
+
+	go do(context.Background())
+	<-started
+
+	// The second request queues, the third is rejected as the queue is full.
+	go do(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	do(context.Background())
+	if code := <-codes; code != http.StatusTooManyRequests {
+		t.Errorf("queue full: StatusCode = %v, want 429", code)
+	}
+
+	// Releasing the slot lets the queued request through.
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	wantCodes(t, []int{<-codes, <-codes}, []int{200, 200})
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	l := httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{MaxWait: 50 * time.Millisecond})
+	h := l.Handler(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	begin := time.Now()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("StatusCode = %v, want 429", recorder.Code)
+	}
+	if waited := time.Since(begin); waited < 50*time.Millisecond {
+		t.Errorf("waited %v, want at least MaxWait", waited)
+	}
+
+	// Canceled requests stop waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{MaxWait: time.Hour})
+	h = l.Handler(blockingHandler(started, release))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("canceled: StatusCode = %v, want 429", recorder.Code)
+	}
+}
+
+func TestLocalConcurrencyCounterLeases(t *testing.T) {
+	ctx := context.Background()
+	c := httprate.NewLocalConcurrencyCounter()
+
+	if _, ok, _ := c.Acquire(ctx, "k", "a", 1, 20*time.Millisecond); !ok {
+		t.Fatal("Acquire(a): want ok")
+	}
+	if _, ok, _ := c.Acquire(ctx, "k", "b", 1, time.Minute); ok {
+		t.Error("Acquire(b) over limit: want !ok")
+	}
+	if _, ok, _ := c.Acquire(ctx, "k", "a", 1, 20*time.Millisecond); !ok {
+		t.Error("Acquire(a) again: want ok, extending the lease")
+	}
+
+	// Expired leases free their slot, e.g. if an instance crashed.
+	time.Sleep(30 * time.Millisecond)
+	if n, ok, _ := c.Acquire(ctx, "k", "b", 1, time.Minute); !ok || n != 1 {
+		t.Errorf("Acquire(b) after expiry = %v, %v, want 1, true", n, ok)
+	}
+	c.Release(ctx, "k", "b")
+	if n := c.InFlight("k"); n != 0 {
+		t.Errorf("InFlight after Release = %v, want 0", n)
+	}
+}
+
+type failingConcurrencyCounter struct{}
+
+func (failingConcurrencyCounter) Acquire(ctx context.Context, key, lease string, limit int, ttl time.Duration) (int, bool, error) {
+	return 0, false, errors.New("counter unavailable")
+}
+
+func (failingConcurrencyCounter) Release(ctx context.Context, key, lease string) error {
+	return nil
+}
+
+func TestConcurrencyLimiterError(t *testing.T) {
+	l := httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{Counter: failingConcurrencyCounter{}})
+
+	recorder := httptest.NewRecorder()
+	l.Handler(okHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusPreconditionRequired {
+		t.Errorf("StatusCode = %v, want 428", recorder.Code)
+	}
+}
+
+// expiringConcurrencyCounter grants leases once, failing to extend them.
+type expiringConcurrencyCounter struct {
+	httprate.ConcurrencyCounter
+}
+
+func (c expiringConcurrencyCounter) Acquire(ctx context.Context, key, lease string, limit int, ttl time.Duration) (int, bool, error) {
+	if c.ConcurrencyCounter.(*httprate.LocalConcurrencyCounter).InFlight(key) > 0 {
+		return 1, false, nil
+	}
+	return c.ConcurrencyCounter.Acquire(ctx, key, lease, limit, ttl)
+}
+
+func TestConcurrencyLimiterLeaseLost(t *testing.T) {
+	lost := make(chan error, 1)
+	l := httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{
+		Counter:  expiringConcurrencyCounter{httprate.NewLocalConcurrencyCounter()},
+		LeaseTTL: 20 * time.Millisecond,
+		OnLeaseLost: func(r *http.Request, key string, err error) {
+			select {
+			case lost <- err:
+			default:
+			}
+		},
+	})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("StatusCode = %v, want 200", recorder.Code)
+	}
+	select {
+	case err := <-lost:
+		if !errors.Is(err, httprate.ErrLeaseLost) {
+			t.Errorf("OnLeaseLost error = %v, want ErrLeaseLost", err)
+		}
+	default:
+		t.Error("OnLeaseLost wasn't called")
+	}
+}
+
+func TestConcurrencyLimiterPolicyHeader(t *testing.T) {
+	for _, name := range []string{"", "uploads"} {
+		l := httprate.NewConcurrencyLimiter(1, httprate.ConcurrencyOptions{Name: name})
+		recorder := httptest.NewRecorder()
+		l.Handler(okHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if got, ok := recorder.Header()["X-Ratelimit-Policy"]; (name == "") == ok || (ok && got[0] != name) {
+			t.Errorf("Name %q: X-RateLimit-Policy = %q, set %v", name, got, ok)
+		}
+	}
+}
+
+func TestNewConcurrencyLimiterInvalidLimit(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewConcurrencyLimiter(0): want panic")
+		}
+	}()
+	httprate.NewConcurrencyLimiter(0, httprate.ConcurrencyOptions{})
+}
@@ -7,6 +7,9 @@ type ctxKey int
 const (
 	incrementKey ctxKey = iota
 	requestLimitKey
+	exemptKey
+	limitShareKey
+	policyPatternKey
 )
 
 func WithIncrement(ctx context.Context, value int) context.Context {
@@ -30,3 +33,47 @@ func getRequestLimit(ctx context.Context) int {
 	}
 	return 0
 }
+
+// withExempt marks the request as exempt from rate-limiting.
+func withExempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exemptKey, true)
+}
+
+func isExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptKey).(bool)
+	return exempt
+}
+
+// limitShare scales the limit of the buckets of a single limiter, see
+// withLimitShare.
+type limitShare struct {
+	limiter *RateLimiter
+	share   float64
+}
+
+// withLimitShare scales the limit of the request's buckets in l by share,
+// after any WithRequestLimit override; a zero share rejects the request.
+// Other limiters the request goes through, e.g. downstream, aren't affected.
+func withLimitShare(ctx context.Context, l *RateLimiter, share float64) context.Context {
+	return context.WithValue(ctx, limitShareKey, limitShare{limiter: l, share: share})
+}
+
+// getLimitShare returns the share l's limits are scaled by, if any.
+func getLimitShare(ctx context.Context, l *RateLimiter) (float64, bool) {
+	ls, ok := ctx.Value(limitShareKey).(limitShare)
+	if !ok || ls.limiter != l {
+		return 0, false
+	}
+	return ls.share, true
+}
+
+// withPolicyPattern sets the pattern of the PolicySet route matching the
+// request, for the "route" and "pattern" policy keys.
+func withPolicyPattern(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, policyPatternKey, pattern)
+}
+
+func getPolicyPattern(ctx context.Context) string {
+	pattern, _ := ctx.Value(policyPatternKey).(string)
+	return pattern
+}
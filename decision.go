@@ -0,0 +1,79 @@
+package httprate
+
+import (
+	"net/http"
+	"time"
+)
+
+// Outcome is the result of a rate-limit check.
+type Outcome string
+
+const (
+	OutcomeAllowed Outcome = "allowed"
+	OutcomeLimited Outcome = "limited"
+	OutcomeError   Outcome = "error"
+
+	// OutcomeExempt is a request let through without being counted (see
+	// WithExempt). Its Decision only has Limiter, Key, Reset and Outcome set.
+	OutcomeExempt Outcome = "exempt"
+
+	// OutcomeDenied is a request rejected by a denylist (see WithDeny). Its
+	// Decision only has Limiter, Key, Reset and Outcome set.
+	OutcomeDenied Outcome = "denied"
+)
+
+// Decision describes the outcome of a single rate-limit check.
+type Decision struct {
+	// Limiter is the name of the limiter (see WithName).
+	Limiter string
+
+	// Key is the rate-limit key the decision was made for, as returned by the
+	// KeyFunc. When a request is checked against several keys (see
+	// WithCompositeKeys), it is the key of the most restrictive bucket. It is
+	// empty if the KeyFunc failed.
+	Key string
+
+	Limit     int       // Request limit for the key in the current window.
+	Rate      int       // Requests counted in the sliding window, before this request.
+	Remaining int       // Requests left in the sliding window, after this request.
+	Increment int       // Amount this request counts for (see WithIncrement).
+	Reset     time.Time // End of the current window.
+
+	Outcome Outcome
+}
+
+// DecisionHooks are callbacks observing every decision made by a limiter (see
+// WithDecisionHooks), e.g. to feed audit logs, alerting or abuse detection.
+// Any of them may be nil.
+//
+// Hooks are called synchronously on the request path, after the rate-limit
+// headers are set and before the limit or error handler responds. They must
+// not write to the response, and should hand off any slow work.
+type DecisionHooks struct {
+	// OnAllow is called for every request that is let through, including
+	// exempt ones.
+	OnAllow func(r *http.Request, d Decision)
+
+	// OnLimit is called for every request that is rate-limited or denied.
+	OnLimit func(r *http.Request, d Decision)
+
+	// OnError is called when the KeyFunc or the LimitCounter fails.
+	OnError func(r *http.Request, d Decision, err error)
+}
+
+// WithDecisionHooks registers callbacks observing the limiter's decisions
+// without affecting the response. It can be given more than once; hooks are
+// called in the order they were registered.
+//
+//	r.Use(httprate.LimitBy(100, time.Minute, clientIPKey,
+//		httprate.WithDecisionHooks(httprate.DecisionHooks{
+//			OnLimit: func(r *http.Request, d httprate.Decision) {
+//				abuse.Report(d.Key, d.Rate)
+//			},
+//		}),
+//	))
+func WithDecisionHooks(hooks DecisionHooks) Option {
+	return func(rl *RateLimiter) {
+		rl.hooks = append(rl.hooks, hooks)
+	}
+}
@@ -0,0 +1,89 @@
+package httprate_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestDecisionHooks(t *testing.T) {
+	var allowed, limited []httprate.Decision
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(2, time.Minute, httprate.KeyByEndpoint,
+		httprate.WithName("api"),
+		httprate.WithDecisionHooks(httprate.DecisionHooks{
+			OnAllow: func(r *http.Request, d httprate.Decision) { allowed = append(allowed, d) },
+			OnLimit: func(r *http.Request, d httprate.Decision) { limited = append(limited, d) },
+		}),
+	)(h)
+
+	var lastCode int
+	for range 3 {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/users", nil))
+		lastCode = recorder.Result().StatusCode
+	}
+
+	// Hooks don't affect the response.
+	if lastCode != http.StatusTooManyRequests {
+		t.Errorf("resp.StatusCode = %v, want 429", lastCode)
+	}
+
+	if len(allowed) != 2 || len(limited) != 1 {
+		t.Fatalf("got %v allowed and %v limited decisions, want 2 and 1", len(allowed), len(limited))
+	}
+
+	want := httprate.Decision{
+		Limiter:   "api",
+		Key:       "/users:", // LimitBy joins keys with a trailing ":"
+		Limit:     2,
+		Rate:      1,
+		Remaining: 0,
+		Increment: 1,
+		Reset:     allowed[1].Reset,
+		Outcome:   httprate.OutcomeAllowed,
+	}
+	if allowed[1] != want {
+		t.Errorf("allowed decision = %+v, want %+v", allowed[1], want)
+	}
+	if !allowed[1].Reset.After(time.Now()) {
+		t.Errorf("Reset = %v, want time in the future", allowed[1].Reset)
+	}
+
+	if d := limited[0]; d.Outcome != httprate.OutcomeLimited || d.Rate != 2 || d.Remaining != 0 || d.Key != "/users:" {
+		t.Errorf("limited decision = %+v", d)
+	}
+}
+
+func TestDecisionHooksOnError(t *testing.T) {
+	keyErr := errors.New("no tenant")
+	var gotErr error
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(2, time.Minute,
+		func(r *http.Request) (string, error) { return "", keyErr },
+		httprate.WithDecisionHooks(httprate.DecisionHooks{
+			OnError: func(r *http.Request, d httprate.Decision, err error) {
+				if d.Outcome != httprate.OutcomeError {
+					t.Errorf("Outcome = %v, want error", d.Outcome)
+				}
+				gotErr = err
+			},
+		}),
+	)(h)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	if gotErr != keyErr {
+		t.Errorf("OnError err = %v, want %v", gotErr, keyErr)
+	}
+	if respCode := recorder.Result().StatusCode; respCode != http.StatusPreconditionRequired {
+		t.Errorf("resp.StatusCode = %v, want %v", respCode, http.StatusPreconditionRequired)
+	}
+}
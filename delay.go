@@ -0,0 +1,134 @@
+package httprate
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithDelay makes requests over the limit wait until the sliding window allows
+// them, instead of being rejected right away, smoothing bursts from batch
+// clients into a steady rate:
+//
+//	r.Use(httprate.LimitBy(100, time.Minute, clientKey,
+//		httprate.WithDelay(10*time.Second, 50)))
+//
+// A request is rejected as usual if the wait would exceed maxDelay, or if
+// maxQueue requests are already waiting for the same key (zero means no
+// bound). Waiting
+// requests stop waiting, and are rejected, when their context is canceled.
+// OnLimit and RespondOnLimit block while the request waits.
+//
+// The wait assumes no other request is counted meanwhile; a request beaten to
+// the freed capacity waits again, within the same maxDelay.
+func WithDelay(maxDelay time.Duration, maxQueue int) Option {
+	return func(rl *RateLimiter) {
+		rl.delay = &delayQueue{maxDelay: maxDelay, maxQueue: maxQueue, waiting: make(map[string]int)}
+	}
+}
+
+type delayQueue struct {
+	maxDelay time.Duration
+	maxQueue int
+	waiting  map[string]int // requests waiting, by key
+	mu       sync.Mutex
+}
+
+// enqueue takes a place in key's queue, returning false if it is full.
+func (q *delayQueue) enqueue(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maxQueue > 0 && q.waiting[key] >= q.maxQueue {
+		return false
+	}
+	q.waiting[key]++
+	return true
+}
+
+func (q *delayQueue) dequeue(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.waiting[key]--; q.waiting[key] == 0 {
+		delete(q.waiting, key)
+	}
+}
+
+// onLimitDelayed is onLimit, waiting for the limit to allow the request.
+func (l *RateLimiter) onLimitDelayed(w http.ResponseWriter, r *http.Request, keys []string) Outcome {
+	deadline := time.Now().Add(l.delay.maxDelay)
+	queue := strings.Join(keys, "\x00") // requests with the same keys queue together
+	queued := false
+	defer func() {
+		if queued {
+			l.delay.dequeue(queue)
+		}
+	}()
+
+	for {
+		outcome, delay := l.check(w, r, keys, time.Until(deadline))
+		if delay == 0 {
+			return outcome
+		}
+
+		if !queued {
+			if !l.delay.enqueue(queue) {
+				outcome, _ = l.check(w, r, keys, 0)
+				return outcome
+			}
+			queued = true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			outcome, _ = l.check(w, r, keys, 0)
+			return outcome
+		case <-timer.C:
+		}
+	}
+}
+
+// delayFor returns how long until all buckets allow increment more requests,
+// assuming no other requests are counted meanwhile, or false if they never
+// will.
+func delayFor(buckets []bucket, now time.Time, increment int) (time.Duration, bool) {
+	var delay time.Duration
+	for i := range buckets {
+		d, ok := buckets[i].delay(now, increment)
+		if !ok {
+			return 0, false
+		}
+		delay = max(delay, d)
+	}
+	// Don't spin on rounding errors.
+	return max(delay, time.Millisecond), true
+}
+
+// delay returns how long until b allows increment more requests, or false if
+// it never will.
+func (b *bucket) delay(now time.Time, increment int) (time.Duration, bool) {
+	free := float64(b.limit - increment)
+	switch {
+	case free < 0:
+		return 0, false
+	case b.rate <= free:
+		return 0, true
+	case b.fixed:
+		return b.reset.Sub(now), true
+	}
+
+	elapsed := now.Sub(b.currentWindow)
+	if float64(b.curr) <= free {
+		// The previous window's weight decreases as the sliding window leaves it.
+		allowed := time.Duration(float64(b.window) * (1 - (free-float64(b.curr))/float64(b.prev)))
+		return allowed - elapsed, true
+	}
+	// Wait for the next window, where the current window becomes the previous
+	// one.
+	allowed := time.Duration(float64(b.window) * (1 - free/float64(b.curr)))
+	return b.window - elapsed + allowed, true
+}
@@ -0,0 +1,142 @@
+package httprate_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestWithDelay(t *testing.T) {
+	h := httprate.LimitBy(2, 200*time.Millisecond, httprate.Key("batch"),
+		httprate.WithDelay(time.Second, 0))(okHandler())
+
+	var codes []int
+	begin := time.Now()
+	for range 3 {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, recorder.Code)
+	}
+	wantCodes(t, codes, []int{200, 200, 200})
+
+	// The third request waits for the next window, until half of the previous
+	// one has slid out.
+	if waited := time.Since(begin); waited < 250*time.Millisecond || waited > time.Second {
+		t.Errorf("waited %v, want about 300ms", waited)
+	}
+}
+
+func TestWithDelayBounds(t *testing.T) {
+	t.Run("max delay", func(t *testing.T) {
+		h := httprate.LimitBy(1, time.Minute, httprate.Key("batch"),
+			httprate.WithDelay(50*time.Millisecond, 0))(okHandler())
+
+		var codes []int
+		begin := time.Now()
+		for range 2 {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			codes = append(codes, recorder.Code)
+		}
+		wantCodes(t, codes, []int{200, 429})
+		if waited := time.Since(begin); waited > 40*time.Millisecond {
+			t.Errorf("waited %v, want immediate rejection", waited)
+		}
+	})
+
+	t.Run("max queue", func(t *testing.T) {
+		h := httprate.LimitBy(1, 200*time.Millisecond, httprate.Key("batch"),
+			httprate.WithDelay(time.Second, 1))(okHandler())
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var codes []int
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder := httptest.NewRecorder()
+				h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+				mu.Lock()
+				codes = append(codes, recorder.Code)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		// One request queues and is let through, the other finds the queue full.
+		slices.Sort(codes)
+		wantCodes(t, codes, []int{200, 429})
+	})
+
+	t.Run("max queue per key", func(t *testing.T) {
+		h := httprate.LimitBy(1, 200*time.Millisecond, httprate.KeyByHeader("X-Client"),
+			httprate.WithDelay(time.Second, 1))(okHandler())
+		do := func(client string) int {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Client", client)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req)
+			return recorder.Code
+		}
+		do("a")
+		do("b")
+
+		// Each client has its own queue.
+		codes := make([]int, 2)
+		var wg sync.WaitGroup
+		for i, client := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes[i] = do(client)
+			}()
+		}
+		wg.Wait()
+		wantCodes(t, codes, []int{200, 200})
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		h := httprate.LimitBy(1, time.Minute, httprate.Key("batch"),
+			httprate.WithDelay(2*time.Minute, 0))(okHandler())
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Errorf("StatusCode = %v, want 429", recorder.Code)
+		}
+	})
+}
+
+func TestWithDelayDecisions(t *testing.T) {
+	var mu sync.Mutex
+	var outcomes []httprate.Outcome
+	record := func(r *http.Request, d httprate.Decision) {
+		mu.Lock()
+		outcomes = append(outcomes, d.Outcome)
+		mu.Unlock()
+	}
+	h := httprate.LimitBy(1, 100*time.Millisecond, httprate.Key("batch"),
+		httprate.WithDelay(time.Second, 0),
+		httprate.WithDecisionHooks(httprate.DecisionHooks{OnAllow: record, OnLimit: record}),
+	)(okHandler())
+
+	for range 2 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	// Waiting isn't reported as a decision.
+	if len(outcomes) != 2 || outcomes[0] != httprate.OutcomeAllowed || outcomes[1] != httprate.OutcomeAllowed {
+		t.Errorf("outcomes = %v, want [allowed allowed]", outcomes)
+	}
+}
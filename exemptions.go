@@ -0,0 +1,206 @@
+package httprate
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// WithExempt exempts requests matching fn from rate-limiting, e.g. health
+// checks or CORS preflight requests. Exempt requests are let through without
+// being counted; they get the Exempt response header instead of the rate-limit
+// headers, and an OutcomeExempt decision (see WithDecisionHooks).
+//
+//	httprate.WithExempt(func(r *http.Request) bool {
+//		return r.Method == http.MethodOptions || r.URL.Path == "/healthz"
+//	})
+//
+// It can be given more than once; a request matching any predicate is exempt.
+// Predicates that only need the request, such as fn or WithExemptIPs', are
+// evaluated before the request is keyed by the Handler, so exempt requests
+// pass even if their KeyFunc fails.
+func WithExempt(fn func(r *http.Request) bool) Option {
+	return func(rl *RateLimiter) {
+		rl.exemptReqFns = append(rl.exemptReqFns, fn)
+	}
+}
+
+// WithExemptKeys exempts the given rate-limit keys, e.g. internal service
+// accounts, from rate-limiting (see WithExempt). The trailing ":" JoinKeys
+// appends to keys is ignored.
+func WithExemptKeys(keys ...string) Option {
+	set := newKeySet(keys)
+	return func(rl *RateLimiter) {
+		rl.exemptKeyFns = append(rl.exemptKeyFns, set.contains)
+	}
+}
+
+// WithExemptIPs exempts clients whose IP, as returned by ipFn, is in ips from
+// rate-limiting (see WithExempt).
+func WithExemptIPs(ipFn func(r *http.Request) string, ips *IPSet) Option {
+	return func(rl *RateLimiter) {
+		rl.exemptReqFns = append(rl.exemptReqFns, func(r *http.Request) bool {
+			return ips.Contains(ipFn(r))
+		})
+	}
+}
+
+// WithDeny rejects requests matching fn immediately, without counting them,
+// with the deny handler (see WithDenyHandler) and an OutcomeDenied decision.
+// Denylists take precedence over exemptions.
+//
+// It can be given more than once; a request matching any predicate is denied.
+func WithDeny(fn func(r *http.Request) bool) Option {
+	return func(rl *RateLimiter) {
+		rl.denyReqFns = append(rl.denyReqFns, fn)
+	}
+}
+
+// WithDenyKeys rejects the given rate-limit keys immediately (see WithDeny).
+// The trailing ":" JoinKeys appends to keys is ignored.
+func WithDenyKeys(keys ...string) Option {
+	set := newKeySet(keys)
+	return func(rl *RateLimiter) {
+		rl.denyKeyFns = append(rl.denyKeyFns, set.contains)
+	}
+}
+
+// WithDenyIPs rejects clients whose IP, as returned by ipFn, is in ips
+// immediately (see WithDeny).
+func WithDenyIPs(ipFn func(r *http.Request) string, ips *IPSet) Option {
+	return func(rl *RateLimiter) {
+		rl.denyReqFns = append(rl.denyReqFns, func(r *http.Request) bool {
+			return ips.Contains(ipFn(r))
+		})
+	}
+}
+
+// WithDenyHandler sets the handler responding to denied requests (see
+// WithDeny). The default responds with 403 Forbidden.
+func WithDenyHandler(h http.HandlerFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.onDenied = h
+	}
+}
+
+// NewIPSet creates a set of networks for WithExemptIPs and WithDenyIPs. Each
+// entry is a CIDR, e.g. "10.0.0.0/8" or "2001:db8::/32", or a bare IP address.
+// IPv4-mapped IPv6 addresses match their IPv4 networks.
+func NewIPSet(cidrs ...string) (*IPSet, error) {
+	s := &IPSet{}
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		s.tree.insert(prefix, &cidrEntry{})
+	}
+	return s, nil
+}
+
+// MustIPSet is like NewIPSet but panics if a CIDR is invalid. It simplifies
+// initialization of package-level sets and Options.
+func MustIPSet(cidrs ...string) *IPSet {
+	s, err := NewIPSet(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// IPSet is an immutable set of networks created by NewIPSet.
+type IPSet struct {
+	tree cidrTree
+}
+
+// Contains reports whether ip is in any of the set's networks.
+func (s *IPSet) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	return s.tree.lookup(addr.WithZone("").Unmap()) != nil
+}
+
+// checkLists matches the request against the limiter's denylists, the
+// exemption set by upstream middleware (see CIDRLimits) and the limiter's
+// exemptions, in that order. It returns the outcome and the key that matched.
+func (l *RateLimiter) checkLists(r *http.Request, keys []string) (Outcome, string, bool) {
+	if matchRequest(l.denyReqFns, r) {
+		return OutcomeDenied, firstKey(keys), true
+	}
+	if key, ok := matchKeys(l.denyKeyFns, keys); ok {
+		return OutcomeDenied, key, true
+	}
+	if l.exemptRequest(r) {
+		return OutcomeExempt, firstKey(keys), true
+	}
+	if key, ok := matchKeys(l.exemptKeyFns, keys); ok {
+		return OutcomeExempt, key, true
+	}
+	return "", "", false
+}
+
+// checkRequestLists is checkLists for the lists that don't need the request's
+// keys, so they apply before the request is keyed. Exemptions only do if no
+// denylist needs the keys, since those take precedence.
+func (l *RateLimiter) checkRequestLists(r *http.Request) (Outcome, bool) {
+	if matchRequest(l.denyReqFns, r) {
+		return OutcomeDenied, true
+	}
+	if len(l.denyKeyFns) == 0 && l.exemptRequest(r) {
+		return OutcomeExempt, true
+	}
+	return "", false
+}
+
+// exemptRequest reports whether the request is exempt regardless of its keys.
+func (l *RateLimiter) exemptRequest(r *http.Request) bool {
+	return isExempt(r.Context()) || matchRequest(l.exemptReqFns, r)
+}
+
+func matchRequest(fns []func(r *http.Request) bool, r *http.Request) bool {
+	for _, fn := range fns {
+		if fn(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKeys returns the first key matching any of fns.
+func matchKeys(fns []func(key string) bool, keys []string) (string, bool) {
+	for _, fn := range fns {
+		for _, key := range keys {
+			if fn(key) {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+func firstKey(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+type keySet map[string]struct{}
+
+func newKeySet(keys []string) keySet {
+	set := make(keySet, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+func (s keySet) contains(key string) bool {
+	if _, ok := s[key]; ok {
+		return true
+	}
+	_, ok := s[strings.TrimSuffix(key, ":")]
+	return ok
+}
@@ -0,0 +1,145 @@
+package httprate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestExemptAndDeny(t *testing.T) {
+	var decisions []httprate.Decision
+	record := func(r *http.Request, d httprate.Decision) { decisions = append(decisions, d) }
+
+	ipFn := func(r *http.Request) string { return r.Header.Get("X-Test-IP") }
+	keyFn := func(r *http.Request) (string, error) { return r.Header.Get("X-Test-User"), nil }
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(1, time.Minute, keyFn,
+		httprate.WithExempt(func(r *http.Request) bool { return r.Method == http.MethodOptions }),
+		httprate.WithExemptKeys("service-account"),
+		httprate.WithExemptIPs(ipFn, httprate.MustIPSet("10.0.0.0/8")),
+		httprate.WithDenyKeys("banned"),
+		httprate.WithDenyIPs(ipFn, httprate.MustIPSet("10.6.6.0/24", "192.0.2.1")),
+		httprate.WithDecisionHooks(httprate.DecisionHooks{OnAllow: record, OnLimit: record}),
+	)(h)
+
+	do := func(method, user, ip string) *http.Response {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("X-Test-User", user)
+		req.Header.Set("X-Test-IP", ip)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		user       string
+		ip         string
+		statusCode int
+		exempt     string
+		outcome    httprate.Outcome
+	}{
+		{name: "first", method: "GET", user: "alice", ip: "203.0.113.1", statusCode: 200, outcome: httprate.OutcomeAllowed},
+		{name: "limited", method: "GET", user: "alice", ip: "203.0.113.1", statusCode: 429, outcome: httprate.OutcomeLimited},
+		{name: "preflight exempt", method: "OPTIONS", user: "alice", ip: "203.0.113.1", statusCode: 200, exempt: "true", outcome: httprate.OutcomeExempt},
+		{name: "key exempt", method: "GET", user: "service-account", ip: "203.0.113.1", statusCode: 200, exempt: "true", outcome: httprate.OutcomeExempt},
+		{name: "key exempt again", method: "GET", user: "service-account", ip: "203.0.113.1", statusCode: 200, exempt: "true", outcome: httprate.OutcomeExempt},
+		{name: "IP exempt", method: "GET", user: "alice", ip: "10.1.2.3", statusCode: 200, exempt: "true", outcome: httprate.OutcomeExempt},
+		{name: "denylist beats exemption", method: "GET", user: "bob", ip: "10.6.6.6", statusCode: 403, outcome: httprate.OutcomeDenied},
+		{name: "IP denied", method: "GET", user: "bob", ip: "192.0.2.1", statusCode: 403, outcome: httprate.OutcomeDenied},
+		{name: "key denied", method: "OPTIONS", user: "banned", ip: "203.0.113.2", statusCode: 403, outcome: httprate.OutcomeDenied},
+		{name: "denied requests aren't counted", method: "GET", user: "bob", ip: "203.0.113.2", statusCode: 200, outcome: httprate.OutcomeAllowed},
+	}
+	for i, tt := range tests {
+		resp := do(tt.method, tt.user, tt.ip)
+		if resp.StatusCode != tt.statusCode {
+			t.Errorf("%s: StatusCode = %v, want %v", tt.name, resp.StatusCode, tt.statusCode)
+		}
+		if exempt := resp.Header.Get("X-RateLimit-Exempt"); exempt != tt.exempt {
+			t.Errorf("%s: X-RateLimit-Exempt = %q, want %q", tt.name, exempt, tt.exempt)
+		}
+		if tt.exempt != "" && resp.Header.Get("X-RateLimit-Limit") != "" {
+			t.Errorf("%s: exempt request got X-RateLimit-Limit header", tt.name)
+		}
+		if len(decisions) != i+1 || decisions[i].Outcome != tt.outcome {
+			t.Fatalf("%s: decisions = %+v, want last outcome %v", tt.name, decisions, tt.outcome)
+		}
+	}
+}
+
+func TestExemptBeforeKeying(t *testing.T) {
+	healthz := func(r *http.Request) bool { return r.URL.Path == "/healthz" }
+	badBot := func(r *http.Request) bool { return r.Header.Get("User-Agent") == "BadBot" }
+	apiKey := httprate.KeyByHeader("X-API-Key", httprate.KeyRequired())
+
+	for _, tt := range []struct {
+		name    string
+		options []httprate.Option
+	}{
+		{name: "no keyed denylist", options: []httprate.Option{httprate.WithExempt(healthz), httprate.WithDeny(badBot)}},
+		{name: "keyed denylist", options: []httprate.Option{httprate.WithExempt(healthz), httprate.WithDeny(badBot), httprate.WithDenyKeys("banned")}},
+	} {
+		router := httprate.LimitBy(10, time.Minute, apiKey, tt.options...)(okHandler())
+		do := func(path, userAgent string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("User-Agent", userAgent)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		// Neither request has an API key.
+		if resp := do("/healthz", "kube-probe"); resp.Code != http.StatusOK || resp.Header().Get("X-RateLimit-Exempt") != "true" {
+			t.Errorf("%s: exempt: StatusCode = %v, X-RateLimit-Exempt = %q, want 200 and true", tt.name, resp.Code, resp.Header().Get("X-RateLimit-Exempt"))
+		}
+		if resp := do("/", "BadBot"); resp.Code != http.StatusForbidden {
+			t.Errorf("%s: denied: StatusCode = %v, want 403", tt.name, resp.Code)
+		}
+		if resp := do("/", "curl"); resp.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s: unkeyed: StatusCode = %v, want 428", tt.name, resp.Code)
+		}
+	}
+}
+
+func TestDenyHandler(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(10, time.Minute, httprate.Key("*"),
+		httprate.WithDeny(func(r *http.Request) bool { return r.Header.Get("User-Agent") == "BadBot" }),
+		httprate.WithDenyHandler(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "go away", http.StatusUnavailableForLegalReasons)
+		}),
+	)(h)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "BadBot")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("StatusCode = %v, want 451", recorder.Code)
+	}
+}
+
+func TestNewIPSet(t *testing.T) {
+	if _, err := httprate.NewIPSet("10.0.0.0/33"); err == nil {
+		t.Error("NewIPSet(invalid): want error")
+	}
+
+	set := httprate.MustIPSet("10.0.0.0/8", "2001:db8::/32")
+	for ip, want := range map[string]bool{
+		"10.2.3.4":        true,
+		"::ffff:10.2.3.4": true,
+		"11.2.3.4":        false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"":                false,
+	} {
+		if got := set.Contains(ip); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
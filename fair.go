@@ -0,0 +1,227 @@
+package httprate
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FairOptions configures a FairLimiter (see NewFairLimiter).
+type FairOptions struct {
+	// Name identifies the limiter in the X-RateLimit-Policy header.
+	Name string
+
+	// KeyFunc keys requests, e.g. by tenant.
+	// Default: a single key, making it a global limit
+	KeyFunc KeyFunc
+
+	// Weight returns the weight of key, e.g. by subscription plan: a key's
+	// fair share is proportional to it. Weights are read when a key becomes
+	// active.
+	// Default: 1 for all keys
+	Weight func(key string) float64
+
+	// Headers are the response headers; only Limit, Remaining, Reset,
+	// RetryAfter and Policy are set. An empty name omits the header.
+	// Default: the same as the rate limiter's (see WithResponseHeaders)
+	Headers *ResponseHeaders
+
+	// OnLimited responds to rejected requests.
+	// Default: 429 Too Many Requests
+	OnLimited http.HandlerFunc
+
+	// OnError responds to requests whose key could not be resolved.
+	// Default: the same as the rate limiter's (see WithErrorHandler)
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewFairLimiter creates a limiter dividing a global capacity of requestLimit
+// requests per windowLength between the keys active in the sliding window,
+// so a noisy tenant can't starve the others the way a single global bucket
+// lets it:
+//
+//	r.Use(httprate.NewFairLimiter(10000, time.Minute, httprate.FairOptions{
+//		KeyFunc: tenantKey,
+//		Weight:  planWeight, // e.g. 3 for enterprise tenants, 1 for others
+//	}).Handler)
+//
+// Every active key is entitled to its fair share of the capacity, in
+// proportion to its weight, and may go over it by borrowing idle capacity,
+// unused by any key. A key below its share is let through even if others
+// borrowed all of the capacity, so the capacity may be exceeded until the
+// borrowed requests slide out of the window. The Limit header reports what the
+// key may use in the window: its share, or more when borrowing.
+//
+// Keys are counted in memory, by this instance only. Each request looks at
+// all active keys, so keep keys coarse, e.g. tenants rather than users.
+func NewFairLimiter(requestLimit int, windowLength time.Duration, opts FairOptions) *FairLimiter {
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = Key("*")
+	}
+	if opts.Weight == nil {
+		opts.Weight = func(string) float64 { return 1 }
+	}
+	if opts.Headers == nil {
+		opts.Headers = &defaultResponseHeaders
+	}
+	if opts.OnLimited == nil {
+		opts.OnLimited = onRateLimited
+	}
+	if opts.OnError == nil {
+		opts.OnError = onError
+	}
+	now := time.Now().UTC()
+	return &FairLimiter{
+		limit:   requestLimit,
+		window:  windowLength,
+		opts:    opts,
+		start:   now,
+		current: now,
+		keys:    make(map[string]*fairKey),
+	}
+}
+
+// FairLimiter is the limiter returned by NewFairLimiter.
+type FairLimiter struct {
+	limit  int
+	window time.Duration
+	opts   FairOptions
+
+	start   time.Time // windows are aligned to it, see roll
+	current time.Time // start of the current window
+	keys    map[string]*fairKey
+	mu      sync.Mutex
+}
+
+type fairKey struct {
+	weight     float64
+	curr, prev int // counts of the current and previous windows
+}
+
+// Handler is a middleware sharing the limit fairly between keys.
+func (l *FairLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := l.opts.KeyFunc(r)
+		if err != nil {
+			l.opts.OnError(w, r, err)
+			return
+		}
+		if l.RespondOnLimit(w, r, key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RespondOnLimit counts the request against key's fair share and sets the
+// response headers. If the request is over it and there is no idle capacity
+// to borrow, it responds and returns true, signaling the caller to halt the
+// request.
+func (l *FairLimiter) RespondOnLimit(w http.ResponseWriter, r *http.Request, key string) bool {
+	headers := l.opts.Headers
+	increment := float64(getIncrement(r.Context()))
+	now := time.Now().UTC()
+
+	l.mu.Lock()
+	l.roll(now)
+	keyLimit, rate := l.allowance(key, now)
+	allowed := rate+increment <= keyLimit
+	if allowed {
+		l.keys[key].curr += int(increment)
+		rate += increment
+	}
+	reset := l.current.Add(l.window)
+	l.mu.Unlock()
+
+	setHeader(w, headers.Limit, strconv.Itoa(int(keyLimit)))
+	setHeader(w, headers.Remaining, strconv.Itoa(int(math.Max(keyLimit-rate, 0))))
+	setHeader(w, headers.Reset, strconv.FormatInt(reset.Unix(), 10))
+	if l.opts.Name != "" {
+		setHeader(w, headers.Policy, l.opts.Name)
+	}
+
+	if !allowed {
+		setHeader(w, headers.RetryAfter, strconv.Itoa(int(l.window.Seconds())))
+		l.opts.OnLimited(w, r)
+		return true
+	}
+	return false
+}
+
+// Share returns key's fair share of the capacity, were it active.
+func (l *FairLimiter) Share(key string) float64 {
+	now := time.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.roll(now)
+	_, totalWeight := l.totals(now)
+	weight := l.weight(key)
+	if _, ok := l.keys[key]; !ok {
+		totalWeight += weight
+	}
+	return float64(l.limit) * weight / totalWeight
+}
+
+// allowance returns how many requests key may use in the sliding window at
+// now, along with its current rate: its fair share, or more if it can borrow
+// capacity no key uses. Must be called with l.mu held.
+func (l *FairLimiter) allowance(key string, now time.Time) (limit, rate float64) {
+	if _, ok := l.keys[key]; !ok {
+		l.keys[key] = &fairKey{weight: l.weight(key)}
+	}
+	global, totalWeight := l.totals(now)
+
+	fk := l.keys[key]
+	rate = l.rate(fk, now)
+	share := float64(l.limit) * fk.weight / totalWeight
+	return math.Max(share, rate+float64(l.limit)-global), rate
+}
+
+// weight returns the weight of key, read when it becomes active.
+func (l *FairLimiter) weight(key string) float64 {
+	if fk, ok := l.keys[key]; ok {
+		return fk.weight
+	}
+	if weight := l.opts.Weight(key); weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// totals returns the rate and the weight of all active keys.
+func (l *FairLimiter) totals(now time.Time) (rate, weight float64) {
+	for _, fk := range l.keys {
+		rate += l.rate(fk, now)
+		weight += fk.weight
+	}
+	return rate, weight
+}
+
+// rate returns the sliding-window rate of fk at now, see slidingRate.
+func (l *FairLimiter) rate(fk *fairKey, now time.Time) float64 {
+	diff := now.Sub(l.current)
+	return float64(fk.prev)*(float64(l.window)-float64(diff))/float64(l.window) + float64(fk.curr)
+}
+
+// roll moves the current window to the one containing now. Keys are active,
+// including while their requests are rejected, until they have no requests in
+// the sliding window.
+func (l *FairLimiter) roll(now time.Time) {
+	windowStart := l.start.Add(now.Sub(l.start).Truncate(l.window))
+	if windowStart.Equal(l.current) {
+		return
+	}
+	consecutive := windowStart.Equal(l.current.Add(l.window))
+	for key, fk := range l.keys {
+		if !consecutive || fk.curr == 0 {
+			delete(l.keys, key)
+			continue
+		}
+		fk.prev, fk.curr = fk.curr, 0
+	}
+	l.current = windowStart
+}
@@ -0,0 +1,96 @@
+package httprate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestFairLimiter(t *testing.T) {
+	l := httprate.NewFairLimiter(10, time.Minute, httprate.FairOptions{
+		KeyFunc: httprate.KeyByHeader("X-Tenant"),
+	})
+	h := l.Handler(okHandler())
+
+	do := func(tenant string, n int) []int {
+		var codes []int
+		for range n {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Tenant", tenant)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req)
+			codes = append(codes, recorder.Code)
+		}
+		return codes
+	}
+
+	// The noisy tenant borrows the capacity the quiet one leaves idle...
+	wantCodes(t, do("quiet", 1), []int{200})
+	wantCodes(t, do("noisy", 10), []int{200, 200, 200, 200, 200, 200, 200, 200, 200, 429})
+
+	// ...but can't starve it of its fair share.
+	wantCodes(t, do("quiet", 5), []int{200, 200, 200, 200, 429})
+	wantCodes(t, do("noisy", 1), []int{429})
+
+	// A new tenant gets a third of the capacity.
+	if share := l.Share("new"); share < 3.3 || share > 3.4 {
+		t.Errorf("Share(new) = %v, want 10/3", share)
+	}
+}
+
+func TestFairLimiterWeights(t *testing.T) {
+	l := httprate.NewFairLimiter(8, time.Minute, httprate.FairOptions{
+		KeyFunc: httprate.KeyByHeader("X-Tenant"),
+		Weight: func(tenant string) float64 {
+			if tenant == "enterprise" {
+				return 3
+			}
+			return 1
+		},
+	})
+	h := l.Handler(okHandler())
+
+	do := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	do("free")
+	if share := l.Share("enterprise"); share != 6 {
+		t.Errorf("Share(enterprise) = %v, want 6", share)
+	}
+
+	// Both tenants active: the free tenant is held to its share of 2 once the
+	// enterprise tenant uses its own.
+	var enterprise []int
+	for range 6 {
+		enterprise = append(enterprise, do("enterprise").Code)
+	}
+	wantCodes(t, enterprise, []int{200, 200, 200, 200, 200, 200})
+
+	resp := do("free")
+	if resp.Code != http.StatusOK || resp.Header().Get("X-RateLimit-Limit") != "2" || resp.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("free: StatusCode = %v, X-RateLimit-Limit = %q, X-RateLimit-Remaining = %q, want 200, 2, 0",
+			resp.Code, resp.Header().Get("X-RateLimit-Limit"), resp.Header().Get("X-RateLimit-Remaining"))
+	}
+	if code := do("free").Code; code != http.StatusTooManyRequests {
+		t.Errorf("free over share: StatusCode = %v, want 429", code)
+	}
+}
+
+func TestFairLimiterPolicyHeader(t *testing.T) {
+	for _, name := range []string{"", "tenants"} {
+		l := httprate.NewFairLimiter(10, time.Minute, httprate.FairOptions{Name: name})
+		recorder := httptest.NewRecorder()
+		l.Handler(okHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if got, ok := recorder.Header()["X-Ratelimit-Policy"]; (name == "") == ok || (ok && got[0] != name) {
+			t.Errorf("Name %q: X-RateLimit-Policy = %q, set %v", name, got, ok)
+		}
+	}
+}
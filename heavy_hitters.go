@@ -0,0 +1,208 @@
+package httprate
+
+import (
+	"container/heap"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NewHeavyHitters creates a tracker of the heaviest keys of a limiter (see
+// WithHeavyHitters): the top keys by number of requests and by number of
+// rejections in the current window, e.g. to block abusive clients proactively.
+//
+// It uses the Space-Saving algorithm, so memory is bounded by capacity no
+// matter how many distinct keys are seen. Every key whose count exceeds
+// 1/capacity of the window's requests is guaranteed to be tracked, with a count
+// overestimated by at most HeavyHitter.Error. Pick a capacity well above the
+// number of keys you want to query.
+func NewHeavyHitters(capacity int) *HeavyHitters {
+	capacity = max(capacity, 1)
+	return &HeavyHitters{
+		requests:   newSpaceSaving(capacity),
+		rejections: newSpaceSaving(capacity),
+	}
+}
+
+// HeavyHitters is the tracker returned by NewHeavyHitters. It is safe for
+// concurrent use.
+type HeavyHitters struct {
+	window     time.Time
+	requests   *spaceSaving
+	rejections *spaceSaving
+	mu         sync.Mutex
+}
+
+// HeavyHitter is a tracked key and its estimated count.
+type HeavyHitter struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+
+	// Error is the maximum overestimation of Count: the true count is in
+	// [Count-Error, Count].
+	Error int `json:"error"`
+}
+
+// WithHeavyHitters tracks the limiter's heaviest keys in hh, which can then be
+// queried in-process with its TopRequests and TopRejections methods. It also
+// makes RateLimiter.TopKeys, and so the admin handler (see NewAdminHandler),
+// report hh's top keys by requests. Exempt requests aren't tracked.
+//
+//	hh := httprate.NewHeavyHitters(1000)
+//	r.Use(httprate.LimitBy(100, time.Minute, clientIPKey, httprate.WithHeavyHitters(hh)))
+//	...
+//	for _, abuser := range hh.TopRejections(10) { ... }
+//
+// If the limiter has a KeyHasher (see WithKeyHasher), hh tracks the hashed
+// keys, mapped back with KeyHasher.Lookup.
+func WithHeavyHitters(hh *HeavyHitters) Option {
+	return func(rl *RateLimiter) {
+		rl.heavyHitters = hh
+		observe := func(r *http.Request, d Decision) {
+			if d.Outcome == OutcomeExempt || d.Key == "" {
+				return
+			}
+			if rl.keyHasher != nil {
+				d.Key = rl.keyHasher.Hash(d.Key)
+			}
+			hh.observe(d)
+		}
+		rl.hooks = append(rl.hooks, DecisionHooks{OnAllow: observe, OnLimit: observe})
+	}
+}
+
+// TopRequests returns up to n keys with the most requests in the current
+// window, heaviest first.
+func (hh *HeavyHitters) TopRequests(n int) []HeavyHitter {
+	hh.mu.Lock()
+	defer hh.mu.Unlock()
+
+	return hh.requests.top(n)
+}
+
+// TopRejections returns up to n keys with the most rejected requests in the
+// current window, heaviest first.
+func (hh *HeavyHitters) TopRejections(n int) []HeavyHitter {
+	hh.mu.Lock()
+	defer hh.mu.Unlock()
+
+	return hh.rejections.top(n)
+}
+
+func (hh *HeavyHitters) observe(d Decision) {
+	hh.mu.Lock()
+	defer hh.mu.Unlock()
+
+	// Decision.Reset marks the end of the decision's window. Keys may have
+	// windows of different lengths (see WithLimitProvider): track the window
+	// ending first, and start over once it has ended.
+	now := time.Now()
+	if !d.Reset.After(now) {
+		// Late decision from a previous window.
+		return
+	}
+	if !now.Before(hh.window) {
+		hh.window = d.Reset
+		hh.requests.reset()
+		hh.rejections.reset()
+	} else if d.Reset.Before(hh.window) {
+		hh.window = d.Reset
+	}
+
+	hh.requests.add(d.Key)
+	if d.Outcome == OutcomeLimited || d.Outcome == OutcomeDenied {
+		hh.rejections.add(d.Key)
+	}
+}
+
+// spaceSaving implements the Space-Saving heavy hitters algorithm (Metwally et
+// al.) over a min-heap of counters.
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*ssEntry
+	heap     ssHeap
+}
+
+type ssEntry struct {
+	key   string
+	count int
+	error int
+	index int // position in the heap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*ssEntry, capacity),
+	}
+}
+
+func (s *spaceSaving) add(key string) {
+	if e, ok := s.entries[key]; ok {
+		e.count++
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		e := &ssEntry{key: key, count: 1}
+		s.entries[key] = e
+		heap.Push(&s.heap, e)
+		return
+	}
+
+	// Replace the smallest counter; the new key inherits its count as error.
+	e := s.heap[0]
+	delete(s.entries, e.key)
+	e.key = key
+	e.error = e.count
+	e.count++
+	s.entries[key] = e
+	heap.Fix(&s.heap, 0)
+}
+
+func (s *spaceSaving) top(n int) []HeavyHitter {
+	all := make([]HeavyHitter, 0, len(s.heap))
+	for _, e := range s.heap {
+		all = append(all, HeavyHitter{Key: e.key, Count: e.count, Error: e.error})
+	}
+	slices.SortFunc(all, func(a, b HeavyHitter) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return a.Error - b.Error
+	})
+	if n >= 0 && n < len(all) {
+		all = all[:n]
+	}
+	return all
+}
+
+func (s *spaceSaving) reset() {
+	clear(s.entries)
+	s.heap = s.heap[:0]
+}
+
+type ssHeap []*ssEntry
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ssHeap) Push(x any) {
+	e := x.(*ssEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *ssHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
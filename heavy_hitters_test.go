@@ -0,0 +1,135 @@
+package httprate_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestHeavyHitters(t *testing.T) {
+	hh := httprate.NewHeavyHitters(50)
+	rl := httprate.NewRateLimiter(5, time.Minute, httprate.WithName("api"), httprate.WithHeavyHitters(hh))
+
+	hit := func(key string, times int) {
+		for range times {
+			rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), key)
+		}
+	}
+
+	hit("abuser", 50)
+	hit("heavy", 20)
+	hit("normal", 3)
+	// Many one-off keys overflow the sketch's capacity.
+	for i := range 100 {
+		hit(fmt.Sprintf("once:%v", i), 1)
+	}
+
+	top := hh.TopRequests(2)
+	if len(top) != 2 || top[0].Key != "abuser" || top[1].Key != "heavy" {
+		t.Fatalf("TopRequests(2) = %+v, want abuser, heavy", top)
+	}
+	if top[0].Count-top[0].Error > 50 || top[0].Count < 50 {
+		t.Errorf("abuser count = %v±%v, want 50 within error", top[0].Count, top[0].Error)
+	}
+
+	rejections := hh.TopRejections(2)
+	if len(rejections) != 2 || rejections[0].Key != "abuser" || rejections[0].Count < 45 || rejections[1].Key != "heavy" {
+		t.Errorf("TopRejections(2) = %+v, want abuser (45 rejections), heavy", rejections)
+	}
+
+	keys, err := rl.TopKeys(1)
+	if err != nil || len(keys) != 1 || keys[0].Key != "abuser" {
+		t.Errorf("TopKeys(1) = %+v, %v, want abuser", keys, err)
+	}
+
+	admin := httprate.NewAdminHandler(func(r *http.Request) bool { return true })
+	admin.Register(rl)
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/limiters/api/top?n=1&by=rejections", nil))
+	var got []httprate.HeavyHitter
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Key != "abuser" {
+		t.Errorf("admin top by rejections = %+v, want abuser", got)
+	}
+}
+
+func TestHeavyHittersWindowReset(t *testing.T) {
+	hh := httprate.NewHeavyHitters(10)
+	rl := httprate.NewRateLimiter(5, 50*time.Millisecond, httprate.WithHeavyHitters(hh))
+
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "old")
+	time.Sleep(60 * time.Millisecond)
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "new")
+
+	if top := hh.TopRequests(-1); len(top) != 1 || top[0].Key != "new" {
+		t.Errorf("TopRequests = %+v, want only the current window's key", top)
+	}
+}
+
+func TestHeavyHittersKeyHasher(t *testing.T) {
+	// Without a reverse mapping, raw keys are never reported.
+	hasher := httprate.NewKeyHasher([]byte("secret"), 0)
+	hh := httprate.NewHeavyHitters(10)
+	rl := httprate.NewRateLimiter(1, time.Minute, httprate.WithName("api"), httprate.WithHeavyHitters(hh), httprate.WithKeyHasher(hasher))
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "token-123")
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "token-123")
+
+	hashed := hasher.Hash("token-123")
+	if top := hh.TopRequests(-1); len(top) != 1 || top[0].Key != hashed {
+		t.Errorf("TopRequests = %+v, want the hashed key", top)
+	}
+	if keys, err := rl.TopKeys(-1); err != nil || len(keys) != 1 || keys[0].Key != hashed {
+		t.Errorf("TopKeys = %+v, %v, want the hashed key", keys, err)
+	}
+
+	// With one, the limiter maps them back.
+	hasher = httprate.NewKeyHasher([]byte("secret"), 10)
+	hh = httprate.NewHeavyHitters(10)
+	rl = httprate.NewRateLimiter(1, time.Minute, httprate.WithName("api"), httprate.WithHeavyHitters(hh), httprate.WithKeyHasher(hasher))
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "token-123")
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "token-123")
+
+	if keys, err := rl.TopKeys(-1); err != nil || len(keys) != 1 || keys[0].Key != "token-123" {
+		t.Errorf("TopKeys = %+v, %v, want token-123", keys, err)
+	}
+	admin := httprate.NewAdminHandler(func(r *http.Request) bool { return true })
+	admin.Register(rl)
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/limiters/api/top?by=rejections", nil))
+	var got []httprate.HeavyHitter
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Key != "token-123" {
+		t.Errorf("admin top by rejections = %+v, want token-123", got)
+	}
+}
+
+func TestHeavyHittersExempt(t *testing.T) {
+	hh := httprate.NewHeavyHitters(10)
+	h := httprate.LimitBy(1, time.Minute, httprate.KeyByIP,
+		httprate.WithExempt(func(r *http.Request) bool { return r.Header.Get("X-Internal") != "" }),
+		httprate.WithExemptKeys("192.0.2.2"),
+		httprate.WithHeavyHitters(hh),
+	)(okHandler())
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.2", "192.0.2.3"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if ip == "192.0.2.3" {
+			req.Header.Set("X-Internal", "1")
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if top := hh.TopRequests(-1); len(top) != 1 || top[0].Key != "192.0.2.1:" {
+		t.Errorf("TopRequests = %+v, want only the client that wasn't exempt", top)
+	}
+}
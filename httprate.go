@@ -40,6 +40,8 @@ type ResponseHeaders struct {
 	Increment  string // Default: X-RateLimit-Increment
 	Reset      string // Default: X-RateLimit-Reset
 	RetryAfter string // Default: Retry-After
+	Policy     string // Default: X-RateLimit-Policy, set to the limiter's name (see WithName)
+	Exempt     string // Default: X-RateLimit-Exempt, set to "true" on exempt requests (see WithExempt)
 }
 
 func Key(key string) func(r *http.Request) (string, error) {
@@ -54,8 +56,9 @@ func Key(key string) func(r *http.Request) (string, error) {
 //   - IPv6 addresses are reduced to their /64 prefix. An IPv6 client typically
 //     controls a whole /64 (2^64 addresses via SLAAC), so keying on the full
 //     address would let it rotate within its own /64 to win a fresh bucket per
-//     request and bypass a per-IP limit. Widen/narrow the prefix yourself if your
-//     clients are delegated a larger block (e.g. a /56 or /48).
+//     request and bypass a per-IP limit. Use IPCanonicalizer to widen/narrow the
+//     prefix if your clients are delegated a larger block (e.g. a /56 or /48),
+//     or to bucket IPv4 clients by subnet.
 //   - Any other string, including "", is returned unchanged.
 //
 // httprate stays router-agnostic, so it does not resolve the client IP for you —
@@ -98,6 +101,9 @@ func CanonicalizeIP(ip string) string {
 	return ipv6.Mask(net.CIDRMask(64, 128)).String()
 }
 
+// KeyByEndpoint keys requests by their URL path. Every distinct path gets its
+// own bucket, so paths with IDs in them (/users/1, /users/2, ...) let a client
+// mint unlimited buckets; use KeyByRoute to key by the matched route instead.
 func KeyByEndpoint(r *http.Request) (string, error) {
 	return r.URL.Path, nil
 }
@@ -110,6 +116,45 @@ func WithKeyFuncs(keyFuncs ...KeyFunc) Option {
 	}
 }
 
+// WithCompositeKeys checks every request against each of keyFuncs as a
+// separate bucket with the same limit, e.g. per-user, per-org and global at
+// once. The request is rate-limited if any bucket is exhausted. Unlike
+// WithKeyFuncs and JoinKeys, which combine the keys into one bucket, this
+// counts each key on its own; it takes precedence over the limiter's key.
+//
+// LimitCounters implementing LimitCounterBatch read and increment all buckets
+// in a single call.
+func WithCompositeKeys(keyFuncs ...KeyFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.compositeFns = keyFuncs
+	}
+}
+
+// WithName names the limiter. The name namespaces every key before it reaches
+// the LimitCounter, so several limiters can share one counter (see
+// WithSharedLimitCounter) without the same key, e.g. a user ID, colliding between
+// them:
+//
+//	counter := httprate.NewLocalLimitCounter(time.Minute)
+//	loginLimiter := httprate.NewRateLimiter(5, time.Minute, httprate.WithName("login"), httprate.WithSharedLimitCounter(counter))
+//	searchLimiter := httprate.NewRateLimiter(100, time.Minute, httprate.WithName("search"), httprate.WithSharedLimitCounter(counter))
+//
+// The name is also reported in the Policy response header.
+func WithName(name string) Option {
+	return func(rl *RateLimiter) {
+		rl.name = name
+	}
+}
+
+// WithMetrics reports the limiter's decisions, LimitCounter latency and tracked
+// key count to c, labelled with the limiter's name (see WithName). Use
+// NewMetrics for a collector serving the Prometheus text format.
+func WithMetrics(c MetricsCollector) Option {
+	return func(rl *RateLimiter) {
+		rl.metrics = c
+	}
+}
+
 func WithLimitHandler(h http.HandlerFunc) Option {
 	return func(rl *RateLimiter) {
 		rl.onRateLimited = h
@@ -125,12 +170,24 @@ func WithErrorHandler(h func(http.ResponseWriter, *http.Request, error)) Option
 func WithLimitCounter(c LimitCounter) Option {
 	return func(rl *RateLimiter) {
 		rl.limitCounter = c
+		rl.sharedCounter = false
+	}
+}
+
+// WithSharedLimitCounter is like WithLimitCounter, for a counter shared with
+// other limiters (see WithName). SetLimit and SetWindow don't notify it with
+// LimitCounter.Config, as that would reconfigure the other limiters too, and
+// SetWindow returns an error.
+func WithSharedLimitCounter(c LimitCounter) Option {
+	return func(rl *RateLimiter) {
+		rl.limitCounter = c
+		rl.sharedCounter = true
 	}
 }
 
 func WithResponseHeaders(headers ResponseHeaders) Option {
 	return func(rl *RateLimiter) {
-		rl.headers = headers
+		rl.config().headers = headers
 	}
 }
 
@@ -0,0 +1,160 @@
+package httprate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedToken is returned, wrapped, by UnverifiedClaims when the bearer
+// token is not a well-formed JWT.
+var ErrMalformedToken = errors.New("httprate: malformed bearer token")
+
+// ClaimsFunc returns the JWT claims of a request, or nil claims if the request
+// is not authenticated. It is typically an accessor for the claims stored in
+// the request context by upstream authentication middleware, e.g. with
+// go-chi/jwtauth:
+//
+//	claimsFn := func(r *http.Request) (map[string]any, error) {
+//		_, claims, err := jwtauth.FromContext(r.Context())
+//		return claims, err
+//	}
+type ClaimsFunc func(r *http.Request) (map[string]any, error)
+
+// KeyByClaim keys requests by the named claim of their JWT, e.g. "sub" or
+// "org_id". Strings are used as is, numbers and booleans are formatted, and
+// requests without the claim are handled according to opts (see KeyRequired
+// and KeyDefault):
+//
+//	r.Use(jwtauth.Verifier(tokenAuth))
+//	r.Use(httprate.LimitBy(100, time.Minute,
+//		httprate.KeyByClaim(claimsFn, "org_id", httprate.KeyRequired())))
+//
+// Errors returned by claimsFn, and claims that are objects or arrays, are
+// returned as errors and handled by the error handler (see WithErrorHandler).
+func KeyByClaim(claimsFn ClaimsFunc, name string, opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc(fmt.Sprintf("claim %q", name), opts, func(r *http.Request) (string, error) {
+		claims, err := claimsFn(r)
+		if err != nil {
+			return "", err
+		}
+		return claimString(claims, name)
+	})
+}
+
+// UnverifiedClaims is a ClaimsFunc decoding the claims of the request's
+// "Authorization: Bearer" JWT WITHOUT verifying its signature. It returns nil
+// claims if the request has no bearer token, and an error wrapping
+// ErrMalformedToken if the token can't be decoded.
+//
+// Anyone can forge an unverified token, including one carrying another user's
+// "sub": a client can then drain that user's bucket and lock them out. Never
+// key limits whose exhaustion hurts the claimed identity with unverified
+// claims, nor resolve limits (see NewClaimLimits) or grant access with them.
+// Read the claims verified by authentication middleware instead, e.g.
+// go-chi/jwtauth's Verifier and FromContext (see ClaimsFunc).
+func UnverifiedClaims(r *http.Request) (map[string]any, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want 3 parts, got %d", ErrMalformedToken, len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var claims map[string]any
+	if err := dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if claims == nil {
+		return nil, fmt.Errorf("%w: claims are not a JSON object", ErrMalformedToken)
+	}
+	return claims, nil
+}
+
+// NewClaimLimits creates a table of per-plan limit overrides, read from the
+// named claim of the request's JWT, e.g. a "plan" claim:
+//
+//	plans := httprate.NewClaimLimits(claimsFn, "plan", map[string]int{
+//		"free":       100,
+//		"pro":        1000,
+//		"enterprise": 10000,
+//	})
+//
+//	r.Use(jwtauth.Verifier(tokenAuth))
+//	r.Use(plans.Handler)
+//	r.Use(httprate.LimitBy(100, time.Minute, httprate.KeyByClaim(claimsFn, "sub")))
+//
+// Requests with no plan, or a plan not in limits, keep the limiter's limit.
+// claimsFn must return verified claims, or clients could pick their own plan.
+func NewClaimLimits(claimsFn ClaimsFunc, claim string, limits map[string]int) *ClaimLimits {
+	return &ClaimLimits{claimsFn: claimsFn, claim: claim, limits: limits}
+}
+
+// ClaimLimits is the table returned by NewClaimLimits.
+type ClaimLimits struct {
+	claimsFn ClaimsFunc
+	claim    string
+	limits   map[string]int
+}
+
+// Lookup returns the limit of the request's plan. ok is false if the request
+// has no plan with a limit.
+func (c *ClaimLimits) Lookup(r *http.Request) (limit int, ok bool) {
+	claims, err := c.claimsFn(r)
+	if err != nil {
+		return 0, false
+	}
+	plan, err := claimString(claims, c.claim)
+	if err != nil || plan == "" {
+		return 0, false
+	}
+	limit, ok = c.limits[plan]
+	return limit, ok && limit > 0
+}
+
+// Handler is a middleware applying the limit of the request's plan to every
+// rate limiter downstream (see WithRequestLimit).
+func (c *ClaimLimits) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit, ok := c.Lookup(r); ok {
+			r = r.WithContext(WithRequestLimit(r.Context(), limit))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claimString returns the named claim formatted as a key, or "" if absent.
+func claimString(claims map[string]any, name string) (string, error) {
+	switch v := claims[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("httprate: claim %q of type %T can't be used as a key", name, v)
+	}
+}
@@ -0,0 +1,121 @@
+package httprate_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// unsignedJWT returns a JWT with the given JSON payload and a bogus signature.
+func unsignedJWT(payload string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func TestUnverifiedClaims(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		wantSub       any
+		wantErr       bool
+	}{
+		{name: "valid", authorization: "Bearer " + unsignedJWT(`{"sub":"alice","org_id":42}`), wantSub: "alice"},
+		{name: "lowercase scheme", authorization: "bearer " + unsignedJWT(`{"sub":"alice"}`), wantSub: "alice"},
+		{name: "no header"},
+		{name: "basic auth", authorization: "Basic YWxpY2U6cGFzcw=="},
+		{name: "two parts", authorization: "Bearer abc.def", wantErr: true},
+		{name: "bad base64", authorization: "Bearer abc.!!!.def", wantErr: true},
+		{name: "bad JSON", authorization: "Bearer " + unsignedJWT(`{"sub":`), wantErr: true},
+		{name: "not an object", authorization: "Bearer " + unsignedJWT(`["alice"]`), wantErr: true},
+		{name: "null", authorization: "Bearer " + unsignedJWT(`null`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			claims, err := httprate.UnverifiedClaims(req)
+			if tt.wantErr {
+				if !errors.Is(err, httprate.ErrMalformedToken) {
+					t.Fatalf("err = %v, want ErrMalformedToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["sub"] != tt.wantSub {
+				t.Errorf("sub = %v, want %v", claims["sub"], tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestKeyByClaim(t *testing.T) {
+	claimsFn := func(claims map[string]any, err error) httprate.ClaimsFunc {
+		return func(r *http.Request) (map[string]any, error) { return claims, err }
+	}
+	claims := map[string]any{"sub": "alice", "org_id": float64(42), "admin": true, "roles": []any{"a"}}
+	errClaims := errors.New("no token")
+	req := httptest.NewRequest("GET", "/", nil)
+
+	tests := []struct {
+		name    string
+		keyFn   httprate.KeyFunc
+		want    string
+		wantErr error
+	}{
+		{name: "string", keyFn: httprate.KeyByClaim(claimsFn(claims, nil), "sub"), want: "alice"},
+		{name: "number", keyFn: httprate.KeyByClaim(claimsFn(claims, nil), "org_id"), want: "42"},
+		{name: "bool", keyFn: httprate.KeyByClaim(claimsFn(claims, nil), "admin"), want: "true"},
+		{name: "missing", keyFn: httprate.KeyByClaim(claimsFn(claims, nil), "plan"), want: ""},
+		{name: "missing default", keyFn: httprate.KeyByClaim(claimsFn(nil, nil), "sub", httprate.KeyDefault("anonymous")), want: "anonymous"},
+		{name: "missing required", keyFn: httprate.KeyByClaim(claimsFn(nil, nil), "sub", httprate.KeyRequired()), wantErr: httprate.ErrMissingKey},
+		{name: "claims error", keyFn: httprate.KeyByClaim(claimsFn(nil, errClaims), "sub"), wantErr: errClaims},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyFn(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := httprate.KeyByClaim(claimsFn(claims, nil), "roles")(req); err == nil {
+		t.Error("array claim: want error")
+	}
+}
+
+func TestClaimLimits(t *testing.T) {
+	claimsFn := httprate.ClaimsFunc(httprate.UnverifiedClaims) // unverified for the test only
+	plans := httprate.NewClaimLimits(claimsFn, "plan", map[string]int{"free": 1, "pro": 3})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := plans.Handler(httprate.LimitBy(2, time.Minute, httprate.KeyByClaim(claimsFn, "sub"))(h))
+
+	get := func(token string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Result().StatusCode
+	}
+
+	free := unsignedJWT(`{"sub":"alice","plan":"free"}`)
+	pro := unsignedJWT(`{"sub":"bob","plan":"pro"}`)
+	unknown := unsignedJWT(`{"sub":"carol","plan":"legacy"}`)
+
+	wantCodes(t, []int{get(free), get(free)}, []int{200, 429})
+	wantCodes(t, []int{get(pro), get(pro), get(pro), get(pro)}, []int{200, 200, 200, 429})
+	wantCodes(t, []int{get(unknown), get(unknown), get(unknown)}, []int{200, 200, 429})
+}
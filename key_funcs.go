@@ -0,0 +1,228 @@
+package httprate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrMissingKey is returned, wrapped, by KeyFuncs created with KeyRequired
+// when the request lacks the value they key by.
+var ErrMissingKey = errors.New("httprate: missing rate-limit key")
+
+// KeyFuncOption configures the KeyFuncs created by KeyByHeader, KeyByCookie,
+// KeyByQuery, KeyByPattern, KeyByRoutePattern and KeyByClaim.
+type KeyFuncOption func(o *keyFuncOptions)
+
+type keyFuncOptions struct {
+	required bool
+	fallback string
+	hashed   bool
+}
+
+// KeyRequired makes the KeyFunc return an error wrapping ErrMissingKey when
+// the request lacks the value, so it is handled by the error handler (see
+// WithErrorHandler) instead of being rate-limited.
+func KeyRequired() KeyFuncOption {
+	return func(o *keyFuncOptions) {
+		o.required = true
+	}
+}
+
+// KeyDefault makes the KeyFunc return value when the request lacks the value,
+// e.g. "anonymous". Without it, the KeyFunc returns "" and all such requests
+// share a single bucket.
+func KeyDefault(value string) KeyFuncOption {
+	return func(o *keyFuncOptions) {
+		o.fallback = value
+	}
+}
+
+// KeyHashed makes the KeyFunc return the SHA-256 hash of the value instead of
+// the value itself, so sensitive values such as API keys or session cookies
+// don't reach the LimitCounter, logs or admin tooling in the clear. Defaults
+// set with KeyDefault are not hashed.
+func KeyHashed() KeyFuncOption {
+	return func(o *keyFuncOptions) {
+		o.hashed = true
+	}
+}
+
+// KeyByHeader keys requests by the value of the named request header, e.g. an
+// API key:
+//
+//	r.Use(httprate.LimitBy(100, time.Minute,
+//		httprate.KeyByHeader("X-API-Key", httprate.KeyRequired(), httprate.KeyHashed())))
+func KeyByHeader(name string, opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc(fmt.Sprintf("header %q", name), opts, func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	})
+}
+
+// KeyByCookie keys requests by the value of the named cookie, e.g. a session.
+func KeyByCookie(name string, opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc(fmt.Sprintf("cookie %q", name), opts, func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return "", nil
+		}
+		return cookie.Value, nil
+	})
+}
+
+// KeyByQuery keys requests by the value of the named query parameter, e.g. a
+// tenant ID.
+func KeyByQuery(param string, opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc(fmt.Sprintf("query parameter %q", param), opts, func(r *http.Request) (string, error) {
+		return r.URL.Query().Get(param), nil
+	})
+}
+
+// KeyByMethod keys requests by their HTTP method.
+func KeyByMethod(r *http.Request) (string, error) {
+	return r.Method, nil
+}
+
+// KeyByPattern keys requests by the net/http ServeMux pattern that matched
+// them (http.Request.Pattern, Go 1.22+), e.g. "GET /users/{id}", so requests
+// to /users/1 and /users/2 share a bucket. The pattern is empty if the request
+// was not routed by a ServeMux, or the limiter runs before routing.
+func KeyByPattern(opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc("route pattern", opts, func(r *http.Request) (string, error) {
+		return r.Pattern, nil
+	})
+}
+
+// RoutePatterner is implemented by routers exposing the pattern of the route
+// that matched a request, such as chi's *chi.Context.
+type RoutePatterner interface {
+	RoutePattern() string
+}
+
+// KeyByRoutePattern keys requests by the route pattern reported by a router,
+// e.g. "/users/{id}", so requests to /users/1 and /users/2 share a bucket.
+// With chi:
+//
+//	keyByRoute := httprate.KeyByRoutePattern(func(r *http.Request) httprate.RoutePatterner {
+//		return chi.RouteContext(r.Context())
+//	})
+//	r.With(httprate.LimitBy(10, time.Second, keyByRoute)).Get("/users/{id}", getUser)
+//
+// Routers only know the full pattern once routing is done: install the limiter
+// on the route itself (chi's r.With) rather than as a top-level middleware.
+func KeyByRoutePattern(fn func(r *http.Request) RoutePatterner, opts ...KeyFuncOption) KeyFunc {
+	return newKeyFunc("route pattern", opts, func(r *http.Request) (string, error) {
+		if rp := fn(r); rp != nil {
+			return rp.RoutePattern(), nil
+		}
+		return "", nil
+	})
+}
+
+// KeyByRoute keys requests by the route that matched them rather than their
+// path, so an attacker can't mint a fresh bucket per request by varying IDs in
+// the path. It uses, in order:
+//
+//  1. the net/http ServeMux pattern (http.Request.Pattern, see KeyByPattern),
+//  2. the pattern reported by the first router returning a non-empty one (see
+//     KeyByRoutePattern),
+//  3. the request path normalized with NormalizePath.
+//
+// For example, with chi:
+//
+//	keyByRoute := httprate.KeyByRoute(func(r *http.Request) httprate.RoutePatterner {
+//		return chi.RouteContext(r.Context())
+//	})
+func KeyByRoute(routers ...func(r *http.Request) RoutePatterner) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		if r.Pattern != "" {
+			return r.Pattern, nil
+		}
+		for _, router := range routers {
+			if rp := router(r); rp != nil {
+				if pattern := rp.RoutePattern(); pattern != "" {
+					return pattern, nil
+				}
+			}
+		}
+		return NormalizePath(r.URL.Path), nil
+	}
+}
+
+// NormalizePath collapses the path segments that look like IDs, i.e. numbers
+// and UUIDs, into "{id}", e.g. "/users/42/orders/7f1c0d2e-8b5a-4d6e-9c3b-2a1f0e9d8c7b"
+// becomes "/users/{id}/orders/{id}". It is the fallback of KeyByRoute for
+// requests with no known route pattern.
+func NormalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isNumeric(segment) || isUUID(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID reports whether s is a UUID in its canonical 8-4-4-4-12 hex form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHex(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func newKeyFunc(what string, opts []KeyFuncOption, valueFn func(r *http.Request) (string, error)) KeyFunc {
+	var o keyFuncOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(r *http.Request) (string, error) {
+		value, err := valueFn(r)
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			if o.required {
+				return "", fmt.Errorf("%w: %s", ErrMissingKey, what)
+			}
+			return o.fallback, nil
+		}
+		if o.hashed {
+			sum := sha256.Sum256([]byte(value))
+			return hex.EncodeToString(sum[:]), nil
+		}
+		return value, nil
+	}
+}
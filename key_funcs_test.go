@@ -0,0 +1,130 @@
+package httprate_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	req := httptest.NewRequest("POST", "/search?tenant=acme", nil)
+	req.Header.Set("X-API-Key", "secret")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s3ss10n"})
+
+	tests := []struct {
+		name    string
+		keyFn   httprate.KeyFunc
+		want    string
+		wantErr error
+	}{
+		{name: "header", keyFn: httprate.KeyByHeader("X-API-Key"), want: "secret"},
+		{name: "cookie", keyFn: httprate.KeyByCookie("session"), want: "s3ss10n"},
+		{name: "query", keyFn: httprate.KeyByQuery("tenant"), want: "acme"},
+		{name: "method", keyFn: httprate.KeyByMethod, want: "POST"},
+		{
+			name:  "hashed",
+			keyFn: httprate.KeyByHeader("X-API-Key", httprate.KeyHashed()),
+			want:  "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		},
+		{name: "missing", keyFn: httprate.KeyByHeader("X-Missing"), want: ""},
+		{name: "missing cookie", keyFn: httprate.KeyByCookie("nope", httprate.KeyDefault("anonymous")), want: "anonymous"},
+		{
+			name:  "missing hashed default",
+			keyFn: httprate.KeyByQuery("nope", httprate.KeyDefault("anonymous"), httprate.KeyHashed()),
+			want:  "anonymous",
+		},
+		{name: "missing required", keyFn: httprate.KeyByQuery("nope", httprate.KeyRequired()), wantErr: httprate.ErrMissingKey},
+		{name: "no pattern", keyFn: httprate.KeyByPattern(httprate.KeyRequired()), wantErr: httprate.ErrMissingKey},
+		{
+			name: "route pattern",
+			keyFn: httprate.KeyByRoutePattern(func(r *http.Request) httprate.RoutePatterner {
+				return routePattern("/users/{id}")
+			}),
+			want: "/users/{id}",
+		},
+		{
+			name: "no router",
+			keyFn: httprate.KeyByRoutePattern(func(r *http.Request) httprate.RoutePatterner {
+				return nil
+			}, httprate.KeyDefault("unrouted")),
+			want: "unrouted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyFn(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type routePattern string
+
+func (p routePattern) RoutePattern() string { return string(p) }
+
+func TestKeyByPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	limiter := httprate.LimitBy(1, time.Minute, httprate.KeyByPattern())
+	mux.Handle("GET /users/{id}", limiter(okHandler()))
+
+	var codes []int
+	for _, path := range []string{"/users/1", "/users/2"} {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		codes = append(codes, recorder.Code)
+	}
+	// Both paths match the same pattern and share its bucket.
+	wantCodes(t, codes, []int{200, 429})
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "/"},
+		{path: "/users", want: "/users"},
+		{path: "/users/42", want: "/users/{id}"},
+		{path: "/users/42/orders/7F1C0D2E-8B5A-4D6E-9C3B-2A1F0E9D8C7B", want: "/users/{id}/orders/{id}"},
+		{path: "/v2/users/alice/", want: "/v2/users/alice/"},
+		{path: "/files/7f1c0d2e8b5a4d6e9c3b2a1f0e9d8c7b", want: "/files/7f1c0d2e8b5a4d6e9c3b2a1f0e9d8c7b"},
+	}
+	for _, tt := range tests {
+		if got := httprate.NormalizePath(tt.path); got != tt.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestKeyByRoute(t *testing.T) {
+	noRouter := func(r *http.Request) httprate.RoutePatterner { return routePattern("") }
+	router := func(r *http.Request) httprate.RoutePatterner { return routePattern("/accounts/{id}") }
+
+	// ServeMux pattern wins.
+	mux := http.NewServeMux()
+	var key string
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		key, _ = httprate.KeyByRoute(router)(r)
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	if key != "GET /users/{id}" {
+		t.Errorf("ServeMux: key = %q, want %q", key, "GET /users/{id}")
+	}
+
+	req := httptest.NewRequest("GET", "/accounts/12", nil)
+	if key, _ := httprate.KeyByRoute(noRouter, router)(req); key != "/accounts/{id}" {
+		t.Errorf("router: key = %q, want %q", key, "/accounts/{id}")
+	}
+	if key, _ := httprate.KeyByRoute(noRouter)(req); key != "/accounts/{id}" {
+		t.Errorf("fallback: key = %q, want %q", key, "/accounts/{id}")
+	}
+}
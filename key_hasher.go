@@ -0,0 +1,106 @@
+package httprate
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// NewKeyHasher creates a KeyHasher HMAC-ing rate-limit keys with secret (see
+// WithKeyHasher), so sensitive keys such as API tokens or emails never reach
+// the LimitCounter, e.g. Redis, in the clear. The secret must be the same
+// across all instances sharing a counter, and kept secret: without it, hashes
+// can't be brute-forced back to low-entropy keys such as emails.
+//
+// If reverseCapacity is positive, the hasher also remembers the original of
+// up to reverseCapacity recently hashed keys, in-process only, so debugging
+// tools such as RateLimiter.TopKeys and the admin handler can show them (see
+// Lookup). The least recently hashed keys are evicted first.
+func NewKeyHasher(secret []byte, reverseCapacity int) *KeyHasher {
+	h := &KeyHasher{secret: secret, capacity: reverseCapacity}
+	if reverseCapacity > 0 {
+		h.reverse = make(map[string]*list.Element, reverseCapacity)
+		h.recent = list.New()
+	}
+	return h
+}
+
+// KeyHasher is the hasher returned by NewKeyHasher. It is safe for concurrent
+// use.
+type KeyHasher struct {
+	secret []byte
+
+	// reverse maps hashes to their entries in recent, which is ordered from
+	// the most recently hashed key, for eviction.
+	reverse  map[string]*list.Element
+	recent   *list.List // of reverseEntry
+	capacity int
+	mu       sync.Mutex
+}
+
+type reverseEntry struct {
+	hashed, key string
+}
+
+// WithKeyHasher HMACs rate-limit keys with h before they reach the
+// LimitCounter:
+//
+//	hasher := httprate.NewKeyHasher([]byte(os.Getenv("RATE_LIMIT_KEY_SECRET")), 1000)
+//	r.Use(httprate.LimitBy(100, time.Minute, httprate.KeyByHeader("X-API-Key"),
+//		httprate.WithKeyHasher(hasher)))
+//
+// RateLimiter methods taking a key, such as Status and Reset, take the
+// original key, and RateLimiter.TopKeys reports the original keys remembered
+// by h, or their hash otherwise. Decision hooks, loggers (see LogOptions) and
+// heavy hitters trackers run in-process and still see the original keys.
+func WithKeyHasher(h *KeyHasher) Option {
+	return func(rl *RateLimiter) {
+		rl.keyHasher = h
+	}
+}
+
+// Hash returns the hex-encoded HMAC-SHA256 of key.
+func (h *KeyHasher) Hash(key string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(key))
+	hashed := hex.EncodeToString(mac.Sum(nil))
+
+	if h.reverse != nil {
+		h.remember(hashed, key)
+	}
+	return hashed
+}
+
+// Lookup returns the key hashed to hashed, if it is still remembered. It
+// always returns false if the hasher keeps no reverse mapping.
+func (h *KeyHasher) Lookup(hashed string) (string, bool) {
+	if h.reverse == nil {
+		return "", false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.reverse[hashed]
+	if !ok {
+		return "", false
+	}
+	return e.Value.(reverseEntry).key, true
+}
+
+func (h *KeyHasher) remember(hashed, key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, ok := h.reverse[hashed]; ok {
+		h.recent.MoveToFront(e)
+		return
+	}
+	if h.recent.Len() >= h.capacity {
+		evicted := h.recent.Remove(h.recent.Back()).(reverseEntry)
+		delete(h.reverse, evicted.hashed)
+	}
+	h.reverse[hashed] = h.recent.PushFront(reverseEntry{hashed: hashed, key: key})
+}
@@ -0,0 +1,105 @@
+package httprate_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// recordingCounter is a LimitCounter recording the keys it stores, standing
+// in for a remote backend.
+type recordingCounter struct {
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+func (c *recordingCounter) Config(int, time.Duration)               {}
+func (c *recordingCounter) Increment(key string, t time.Time) error { return c.IncrementBy(key, t, 1) }
+
+func (c *recordingCounter) IncrementBy(key string, _ time.Time, amount int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[key] += amount
+	return nil
+}
+
+func (c *recordingCounter) Get(key string, _, _ time.Time) (int, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[key], 0, nil
+}
+
+func (c *recordingCounter) TopKeys(n int, _ time.Time) ([]httprate.KeyCount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []httprate.KeyCount
+	for key, count := range c.counts {
+		keys = append(keys, httprate.KeyCount{Key: key, Count: count})
+	}
+	return keys, nil
+}
+
+func TestKeyHasher(t *testing.T) {
+	a := httprate.NewKeyHasher([]byte("secret"), 0)
+	b := httprate.NewKeyHasher([]byte("other secret"), 0)
+	if a.Hash("alice@example.com") != a.Hash("alice@example.com") {
+		t.Error("Hash is not deterministic")
+	}
+	if a.Hash("alice@example.com") == b.Hash("alice@example.com") {
+		t.Error("Hash does not depend on the secret")
+	}
+	if _, ok := a.Lookup(a.Hash("alice@example.com")); ok {
+		t.Error("Lookup without reverse map: want false")
+	}
+
+	h := httprate.NewKeyHasher([]byte("secret"), 2)
+	first, second := h.Hash("first"), h.Hash("second")
+	h.Hash("first") // refreshes first, so second is the least recently hashed
+	third := h.Hash("third")
+	if _, ok := h.Lookup(second); ok {
+		t.Error("Lookup(second): want evicted")
+	}
+	for hashed, want := range map[string]string{first: "first", third: "third"} {
+		if key, ok := h.Lookup(hashed); !ok || key != want {
+			t.Errorf("Lookup(%s) = %q, %v, want %q", hashed, key, ok, want)
+		}
+	}
+}
+
+func TestWithKeyHasher(t *testing.T) {
+	counter := &recordingCounter{}
+	hasher := httprate.NewKeyHasher([]byte("secret"), 10)
+	rl := httprate.NewRateLimiter(2, time.Minute,
+		httprate.WithName("api"),
+		httprate.WithLimitCounter(counter),
+		httprate.WithKeyHasher(hasher),
+	)
+
+	for range 3 {
+		rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "token-123")
+	}
+
+	for key := range counter.counts {
+		if strings.Contains(key, "token-123") {
+			t.Errorf("counter key %q contains the original key", key)
+		}
+		if key != "api:"+hasher.Hash("token-123") {
+			t.Errorf("counter key = %q, want api:<hash>", key)
+		}
+	}
+	if within, rate, err := rl.Status("token-123"); err != nil || !within || rate != 2 {
+		t.Errorf("Status(token-123) = %v, %v, %v, want true, 2, nil", within, rate, err)
+	}
+
+	keys, err := rl.TopKeys(10)
+	if err != nil || len(keys) != 1 || keys[0].Key != "token-123" {
+		t.Errorf("TopKeys = %+v, %v, want token-123", keys, err)
+	}
+}
@@ -0,0 +1,223 @@
+package httprate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitProvider resolves the limit and window of rate-limit keys, e.g. from a
+// subscription plan table (see WithLimitProvider).
+type LimitProvider interface {
+	// KeyLimit returns the limit of key. Zero fields of the returned KeyLimit
+	// fall back to the limiter's request limit and window length. ctx is the
+	// request's context, or context.Background() when called outside of a
+	// request, e.g. by RateLimiter.Status. The trailing ":" JoinKeys appends
+	// to keys is trimmed.
+	KeyLimit(ctx context.Context, key string) (KeyLimit, error)
+}
+
+// LimitProviderFunc is an adapter to use ordinary functions as LimitProviders.
+type LimitProviderFunc func(ctx context.Context, key string) (KeyLimit, error)
+
+// KeyLimit implements LimitProvider.
+func (f LimitProviderFunc) KeyLimit(ctx context.Context, key string) (KeyLimit, error) {
+	return f(ctx, key)
+}
+
+// KeyLimit is the limit of a rate-limit key: Limit requests per Window.
+type KeyLimit struct {
+	Limit  int
+	Window time.Duration
+
+	// Until is when the limit may change, e.g. at a schedule boundary (see
+	// NewSchedule). The Reset header never points past it. Zero means never.
+	Until time.Time
+}
+
+// WithLimitProvider resolves the limit and window of every key with p, instead
+// of using the limiter's request limit and window length for all of them:
+//
+//	plans := httprate.LimitProviderFunc(func(ctx context.Context, tenant string) (httprate.KeyLimit, error) {
+//		plan, err := db.TenantPlan(ctx, tenant)
+//		if err != nil {
+//			return httprate.KeyLimit{}, err
+//		}
+//		return httprate.KeyLimit{Limit: plan.RequestsPerMinute, Window: time.Minute}, nil
+//	})
+//	r.Use(httprate.LimitBy(100, time.Minute, tenantKey, httprate.WithLimitProvider(plans, time.Minute)))
+//
+// The resolved limit is used to decide, in the response headers, and by
+// RateLimiter.Status and RateLimiter.Reset. A limit set on the request context
+// (see WithRequestLimit) still takes precedence. Errors are handled by the
+// error handler (see WithErrorHandler).
+//
+// If cacheTTL is positive, resolved limits are cached per key for up to
+// cacheTTL, so p must then depend on the key only, not on ctx.
+//
+// Keys with a window other than the limiter's are counted separately, in a
+// counter per window length: local counters by default, or counters created
+// with WithLimitCounterFactory. Custom counters set with WithLimitCounter can
+// only count the limiter's window length.
+func WithLimitProvider(p LimitProvider, cacheTTL time.Duration) Option {
+	return func(rl *RateLimiter) {
+		rl.limitProvider = p
+		rl.limitCache = &limitCache{ttl: cacheTTL}
+	}
+}
+
+// WithLimitCounterFactory creates the limiter's LimitCounters with fn, one per
+// window length the limiter counts, e.g. for a LimitProvider returning several
+// windows (see WithLimitProvider). fn is called once per window length, and
+// the counters it returns are configured with LimitCounter.Config.
+func WithLimitCounterFactory(fn func(windowLength time.Duration) LimitCounter) Option {
+	return func(rl *RateLimiter) {
+		rl.counterFactory = fn
+	}
+}
+
+// limitCache caches the limits resolved by a LimitProvider. All entries expire
+// at once, every ttl, which bounds the cache to the keys seen in a ttl.
+type limitCache struct {
+	ttl     time.Duration
+	limits  map[string]KeyLimit
+	expires time.Time
+	mu      sync.Mutex
+}
+
+func (c *limitCache) get(key string, now time.Time) (KeyLimit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !now.Before(c.expires) {
+		clear(c.limits)
+		return KeyLimit{}, false
+	}
+	limit, ok := c.limits[key]
+	return limit, ok
+}
+
+func (c *limitCache) set(key string, limit KeyLimit, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limits == nil || !now.Before(c.expires) {
+		c.limits = make(map[string]KeyLimit)
+		c.expires = now.Add(c.ttl)
+	}
+	c.limits[key] = limit
+}
+
+// bucket is a key's bucket, with its limit and window resolved.
+type bucket struct {
+	key           string // key in the LimitCounter, see counterKey
+	limit         int
+	window        time.Duration
+	counter       LimitCounter
+	currentWindow time.Time
+	reset         time.Time // end of the window, or KeyLimit.Until if sooner
+	fixed         bool      // counted in a fixed calendar window
+	rate          float64
+	curr, prev    int // counts of the current and previous windows, see getRates
+}
+
+// resolveBuckets resolves the bucket of every key at now.
+func (l *RateLimiter) resolveBuckets(ctx context.Context, cfg *limiterConfig, keys []string, now time.Time) ([]bucket, error) {
+	override := getRequestLimit(ctx)
+	share, scaled := getLimitShare(ctx, l)
+
+	buckets := make([]bucket, len(keys))
+	for i, key := range keys {
+		kl, err := l.keyLimit(ctx, cfg, key, now)
+		if err != nil {
+			return nil, err
+		}
+		if override > 0 {
+			kl.Limit = override
+		}
+		if scaled {
+			kl.Limit = int(math.Round(float64(kl.Limit) * share))
+		}
+		counter, err := l.counterFor(cfg, kl.Window)
+		if err != nil {
+			return nil, err
+		}
+		b := bucket{
+			key:           l.counterKey(key),
+			limit:         kl.Limit,
+			window:        kl.Window,
+			counter:       counter,
+			currentWindow: cfg.windowStart(now, kl.Window, l.start),
+			fixed:         cfg.calendar != nil && kl.Window == cfg.windowLength,
+		}
+		b.reset = cfg.windowEnd(b.currentWindow, b.window)
+		if !kl.Until.IsZero() && kl.Until.Before(b.reset) {
+			b.reset = kl.Until
+		}
+		buckets[i] = b
+	}
+	return buckets, nil
+}
+
+// keyLimit returns the limit of key, with the limiter's defaults filled in.
+func (l *RateLimiter) keyLimit(ctx context.Context, cfg *limiterConfig, key string, now time.Time) (KeyLimit, error) {
+	var kl KeyLimit
+	if l.limitProvider != nil {
+		cached := false
+		if l.limitCache.ttl > 0 {
+			kl, cached = l.limitCache.get(key, now)
+		}
+		if !cached {
+			var err error
+			kl, err = l.limitProvider.KeyLimit(ctx, strings.TrimSuffix(key, ":"))
+			if err != nil {
+				return KeyLimit{}, fmt.Errorf("httprate: resolving limit: %w", err)
+			}
+			if l.limitCache.ttl > 0 {
+				l.limitCache.set(key, kl, now)
+			}
+		}
+	}
+
+	if kl.Limit <= 0 {
+		kl.Limit = cfg.requestLimit
+	}
+	if kl.Window <= 0 {
+		kl.Window = cfg.windowLength
+	}
+	return kl, nil
+}
+
+// counterFor returns the LimitCounter counting windows of the given length.
+func (l *RateLimiter) counterFor(cfg *limiterConfig, window time.Duration) (LimitCounter, error) {
+	if window == cfg.windowLength {
+		return l.limitCounter, nil
+	}
+
+	l.countersMu.Lock()
+	defer l.countersMu.Unlock()
+
+	if counter, ok := l.windowCounters[window]; ok {
+		return counter, nil
+	}
+
+	var counter LimitCounter
+	switch {
+	case l.counterFactory != nil:
+		counter = l.counterFactory(window)
+		counter.Config(cfg.requestLimit, window)
+	case l.localCounter:
+		counter = NewLocalLimitCounter(window)
+	default:
+		return nil, fmt.Errorf("httprate: %T cannot count %v windows, see WithLimitCounterFactory", l.limitCounter, window)
+	}
+
+	if l.windowCounters == nil {
+		l.windowCounters = make(map[time.Duration]LimitCounter)
+	}
+	l.windowCounters[window] = counter
+	return counter, nil
+}
@@ -0,0 +1,134 @@
+package httprate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestLimitProvider(t *testing.T) {
+	var calls atomic.Int32
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		calls.Add(1)
+		switch key {
+		case "free":
+			return httprate.KeyLimit{Limit: 1}, nil
+		case "pro":
+			return httprate.KeyLimit{Limit: 3}, nil
+		case "daily":
+			return httprate.KeyLimit{Limit: 1, Window: 24 * time.Hour}, nil
+		case "broken":
+			return httprate.KeyLimit{}, errors.New("plan table unavailable")
+		}
+		return httprate.KeyLimit{}, nil // limiter defaults
+	})
+
+	h := httprate.Limit(2, time.Minute,
+		httprate.WithKeyFuncs(func(r *http.Request) (string, error) { return r.Header.Get("X-Tenant"), nil }),
+		httprate.WithLimitProvider(plans, time.Minute),
+	)(okHandler())
+
+	do := func(tenant string, ctx context.Context) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		req.Header.Set("X-Tenant", tenant)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+	codes := func(tenant string, n int) []int {
+		var codes []int
+		for range n {
+			codes = append(codes, do(tenant, context.Background()).StatusCode)
+		}
+		return codes
+	}
+
+	wantCodes(t, codes("free", 2), []int{200, 429})
+	wantCodes(t, codes("pro", 4), []int{200, 200, 200, 429})
+	wantCodes(t, codes("other", 3), []int{200, 200, 429})
+	wantCodes(t, codes("broken", 1), []int{http.StatusPreconditionRequired})
+
+	// A limit on the request context takes precedence.
+	if got := do("override", httprate.WithRequestLimit(context.Background(), 5)).Header.Get("X-RateLimit-Limit"); got != "5" {
+		t.Errorf("override: X-RateLimit-Limit = %q, want 5", got)
+	}
+
+	// Keys with another window get their own counter and Reset.
+	resp := do("daily", context.Background())
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if until := time.Until(time.Unix(reset, 0)); resp.StatusCode != 200 || until < time.Minute || until > 24*time.Hour {
+		t.Errorf("daily: code %v, reset in %v, want 200 and a reset within a day", resp.StatusCode, until)
+	}
+	wantCodes(t, codes("daily", 1), []int{429})
+
+	// Resolved limits are cached per key.
+	if n := calls.Load(); n != 6 {
+		t.Errorf("provider called %v times, want 6 (once per key)", n)
+	}
+}
+
+func TestLimitProviderStatus(t *testing.T) {
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		return httprate.KeyLimit{Limit: 1, Window: time.Hour}, nil
+	})
+	rl := httprate.NewRateLimiter(100, time.Minute, httprate.WithLimitProvider(plans, 0))
+
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "alice")
+	rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "alice")
+	if allowed, rate, err := rl.Status("alice"); err != nil || allowed != true || rate != 1 {
+		t.Errorf("Status(alice) = %v, %v, %v, want true, 1, nil", allowed, rate, err)
+	}
+	if limited := rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "alice"); !limited {
+		t.Error("want alice limited at 1 request per hour")
+	}
+
+	if err := rl.Reset("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, rate, _ := rl.Status("alice"); rate != 0 {
+		t.Errorf("Status(alice) after Reset: rate = %v, want 0", rate)
+	}
+}
+
+func TestLimitCounterFactory(t *testing.T) {
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		if key == "hourly" {
+			return httprate.KeyLimit{Window: time.Hour}, nil
+		}
+		return httprate.KeyLimit{}, nil
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// Custom counters only count the limiter's window.
+	rl := httprate.NewRateLimiter(1, time.Minute,
+		httprate.WithLimitCounter(&recordingCounter{}),
+		httprate.WithLimitProvider(plans, 0),
+	)
+	if rl.OnLimit(httptest.NewRecorder(), req, "minutely") {
+		t.Error("minutely: want allowed")
+	}
+	if _, _, err := rl.Status("hourly"); err == nil {
+		t.Error("hourly without a counter factory: want error")
+	}
+
+	counters := map[time.Duration]*recordingCounter{}
+	rl = httprate.NewRateLimiter(1, time.Minute,
+		httprate.WithLimitCounterFactory(func(windowLength time.Duration) httprate.LimitCounter {
+			counters[windowLength] = &recordingCounter{}
+			return counters[windowLength]
+		}),
+		httprate.WithLimitProvider(plans, 0),
+	)
+	rl.OnLimit(httptest.NewRecorder(), req, "minutely")
+	rl.OnLimit(httptest.NewRecorder(), req, "hourly")
+	if len(counters) != 2 || counters[time.Minute].counts["minutely"] != 1 || counters[time.Hour].counts["hourly"] != 1 {
+		t.Errorf("counters = %+v, want one per window", counters)
+	}
+}
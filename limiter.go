@@ -1,10 +1,15 @@
 package httprate
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,18 +20,55 @@ type LimitCounter interface {
 	Get(key string, currentWindow, previousWindow time.Time) (int, int, error)
 }
 
+// LimitCounterBatch is an optional interface a LimitCounter can implement to
+// read and increment several keys in a single call, e.g. one round-trip to a
+// remote backend instead of one per key. RateLimiter uses it whenever a request
+// is checked against more than one key (see WithCompositeKeys and OnLimitKeys)
+// and falls back to per-key Get/IncrementBy calls otherwise.
+//
+// GetBatch returns the current and previous window counts in the same order as
+// keys.
+type LimitCounterBatch interface {
+	GetBatch(keys []string, currentWindow, previousWindow time.Time) ([]int, []int, error)
+	IncrementBatch(keys []string, currentWindow time.Time, amount int) error
+}
+
+// LimitCounterResetter is an optional interface for LimitCounters that can
+// forget a key's counts, used by RateLimiter.Reset.
+type LimitCounterResetter interface {
+	Reset(key string, currentWindow, previousWindow time.Time) error
+}
+
+// LimitCounterTopKeys is an optional interface for LimitCounters that can list
+// their heaviest keys in the current window, used by RateLimiter.TopKeys. It
+// returns up to n keys, heaviest first, or all of them if n is negative.
+type LimitCounterTopKeys interface {
+	TopKeys(n int, currentWindow time.Time) ([]KeyCount, error)
+}
+
+// KeyCount is a rate-limit key along with the number of requests counted for it.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+var defaultResponseHeaders = ResponseHeaders{
+	Limit:      "X-RateLimit-Limit",
+	Remaining:  "X-RateLimit-Remaining",
+	Increment:  "X-RateLimit-Increment",
+	Reset:      "X-RateLimit-Reset",
+	RetryAfter: "Retry-After",
+	Policy:     "X-RateLimit-Policy",
+	Exempt:     "X-RateLimit-Exempt",
+}
+
 func NewRateLimiter(requestLimit int, windowLength time.Duration, options ...Option) *RateLimiter {
-	rl := &RateLimiter{
+	rl := &RateLimiter{}
+	rl.cfg.Store(&limiterConfig{
 		requestLimit: requestLimit,
 		windowLength: windowLength,
-		headers: ResponseHeaders{
-			Limit:      "X-RateLimit-Limit",
-			Remaining:  "X-RateLimit-Remaining",
-			Increment:  "X-RateLimit-Increment",
-			Reset:      "X-RateLimit-Reset",
-			RetryAfter: "Retry-After",
-		},
-	}
+		headers:      defaultResponseHeaders,
+	})
 
 	for _, opt := range options {
 		opt(rl)
@@ -36,15 +78,25 @@ func NewRateLimiter(requestLimit int, windowLength time.Duration, options ...Opt
 		rl.keyFn = Key("*")
 	}
 
-	if rl.limitCounter == nil {
+	if cfg := rl.config(); cfg.calendar != nil {
+		windowLength = cfg.calendar.nominalLength()
+		cfg.windowLength = windowLength
+	}
+
+	switch {
+	case rl.limitCounter != nil:
+		rl.limitCounter.Config(requestLimit, windowLength)
+	case rl.counterFactory != nil:
+		rl.limitCounter = rl.counterFactory(windowLength)
+		rl.limitCounter.Config(requestLimit, windowLength)
+	default:
 		// Align windows to this limiter's start instant, not the wall clock, so resets
 		// spread out instead of all snapping to the same instant (e.g. the exact second).
 		// Safe only in-process; custom counters (e.g. Redis) stay wall-clock-aligned.
-		start := time.Now().UTC()
-		rl.windowOffset = start.Sub(start.Truncate(windowLength))
+		rl.start = time.Now().UTC()
+		rl.config().windowOffset = rl.start.Sub(rl.start.Truncate(windowLength))
+		rl.localCounter = true
 		rl.limitCounter = NewLocalLimitCounter(windowLength)
-	} else {
-		rl.limitCounter.Config(requestLimit, windowLength)
 	}
 
 	if rl.onRateLimited == nil {
@@ -55,19 +107,52 @@ func NewRateLimiter(requestLimit int, windowLength time.Duration, options ...Opt
 		rl.onError = onError
 	}
 
+	if rl.onDenied == nil {
+		rl.onDenied = onDenied
+	}
+
+	if rl.logger != nil {
+		// WithKeyHasher may be given after WithLogger.
+		rl.logger.hasher = rl.keyHasher
+	}
+
 	return rl
 }
 
 type RateLimiter struct {
-	requestLimit  int
-	windowLength  time.Duration
-	windowOffset  time.Duration
+	name          string
+	cfg           atomic.Pointer[limiterConfig]
+	cfgMu         sync.Mutex // serializes reconfigurations, see SetLimit
 	keyFn         KeyFunc
+	compositeFns  []KeyFunc
 	limitCounter  LimitCounter
 	onRateLimited http.HandlerFunc
 	onError       func(http.ResponseWriter, *http.Request, error)
-	headers       ResponseHeaders
-	mu            sync.Mutex
+	metrics       MetricsCollector
+	hooks         []DecisionHooks
+	logger        *decisionLogger
+	heavyHitters  *HeavyHitters
+	keyHasher     *KeyHasher
+	limitProvider LimitProvider
+	limitCache    *limitCache
+
+	// localCounter is set if the limiter created its own local counters, whose
+	// windows are aligned to start rather than to the wall clock.
+	localCounter bool
+	// sharedCounter is set if the limitCounter was given with
+	// WithSharedLimitCounter, so isn't reconfigured.
+	sharedCounter  bool
+	start          time.Time
+	counterFactory func(windowLength time.Duration) LimitCounter
+	windowCounters map[time.Duration]LimitCounter
+	countersMu     sync.Mutex
+	exemptReqFns   []func(r *http.Request) bool
+	exemptKeyFns   []func(key string) bool
+	denyReqFns     []func(r *http.Request) bool
+	denyKeyFns     []func(key string) bool
+	onDenied       http.HandlerFunc
+	delay          *delayQueue
+	mu             sync.Mutex
 }
 
 // OnLimit checks the rate limit for the given key and updates the response headers accordingly.
@@ -75,48 +160,150 @@ type RateLimiter struct {
 // it increments the request count and returns false. This method does not send an HTTP response,
 // so the caller must handle the response themselves or use the RespondOnLimit() method instead.
 func (l *RateLimiter) OnLimit(w http.ResponseWriter, r *http.Request, key string) bool {
-	currentWindow := l.currentWindow(time.Now().UTC())
+	return halts(l.onLimit(w, r, []string{key}))
+}
+
+// OnLimitKeys is like OnLimit, but checks the request against several keys at
+// once, each being a separate bucket with the same limit. The request is halted
+// if any of the buckets is exhausted; otherwise all of them are incremented. The
+// response headers describe the most restrictive bucket.
+//
+// If the LimitCounter implements LimitCounterBatch, all keys are read and
+// incremented in one call each.
+func (l *RateLimiter) OnLimitKeys(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	return halts(l.onLimit(w, r, keys))
+}
+
+// listed reports the decision for a request matching a denylist or an
+// exemption (see checkLists) and returns its outcome.
+func (l *RateLimiter) listed(w http.ResponseWriter, r *http.Request, outcome Outcome, key string) Outcome {
+	cfg := l.config()
+	currentWindow := cfg.windowStart(time.Now().UTC(), cfg.windowLength, l.start)
+	if outcome == OutcomeExempt {
+		setHeader(w, cfg.headers.Exempt, "true")
+	}
+	l.report(r, Decision{Limiter: l.name, Key: key, Reset: cfg.windowEnd(currentWindow, cfg.windowLength), Outcome: outcome}, nil)
+	return outcome
+}
+
+// halts reports whether a request with the given outcome must be halted.
+func halts(outcome Outcome) bool {
+	return outcome != OutcomeAllowed && outcome != OutcomeExempt
+}
+
+func (l *RateLimiter) onLimit(w http.ResponseWriter, r *http.Request, keys []string) Outcome {
+	if l.delay != nil {
+		return l.onLimitDelayed(w, r, keys)
+	}
+	outcome, _ := l.check(w, r, keys, 0)
+	return outcome
+}
+
+// check makes the rate-limit decision for keys. If the request is over the
+// limit but would be allowed within maxDelay, it returns how long to wait
+// before checking again, without deciding.
+func (l *RateLimiter) check(w http.ResponseWriter, r *http.Request, keys []string, maxDelay time.Duration) (Outcome, time.Duration) {
 	ctx := r.Context()
+	cfg := l.config()
+	now := time.Now().UTC()
+	currentWindow := cfg.windowStart(now, cfg.windowLength, l.start)
+
+	if outcome, key, ok := l.checkLists(r, keys); ok {
+		return l.listed(w, r, outcome, key), 0
+	}
+
+	if l.logger != nil {
+		l.logger.rotate(ctx, l.name, currentWindow, cfg.windowLength)
+	}
+
+	d := Decision{
+		Limiter:   l.name,
+		Key:       firstKey(keys),
+		Limit:     cfg.requestLimit,
+		Increment: getIncrement(ctx),
+		Reset:     cfg.windowEnd(currentWindow, cfg.windowLength),
+	}
 
-	limit := l.requestLimit
-	if val := getRequestLimit(ctx); val > 0 {
-		limit = val
+	buckets, err := l.resolveBuckets(ctx, cfg, keys, now)
+	if err != nil {
+		d.Outcome = OutcomeError
+		l.report(r, d, err)
+		l.onError(w, r, err)
+		return OutcomeError, 0
+	}
+	if len(buckets) > 0 {
+		d.Limit = buckets[0].limit
+		d.Reset = buckets[0].reset
+	}
+	setHeader(w, cfg.headers.Limit, strconv.Itoa(d.Limit))
+	setHeader(w, cfg.headers.Reset, strconv.FormatInt(d.Reset.Unix(), 10))
+	if l.name != "" {
+		setHeader(w, cfg.headers.Policy, l.name)
 	}
-	setHeader(w, l.headers.Limit, strconv.Itoa(limit))
-	setHeader(w, l.headers.Reset, strconv.FormatInt(currentWindow.Add(l.windowLength).Unix(), 10))
 
 	l.mu.Lock()
-	_, rateFloat, err := l.calculateRate(key, limit)
+	err = l.getRates(buckets, now)
 	if err != nil {
 		l.mu.Unlock()
+		d.Outcome = OutcomeError
+		l.report(r, d, err)
 		l.onError(w, r, err)
-		return true
+		return OutcomeError, 0
 	}
-	rate := int(math.Round(rateFloat))
 
-	increment := getIncrement(r.Context())
-	if increment > 1 {
-		setHeader(w, l.headers.Increment, strconv.Itoa(increment))
+	retryAfter := cfg.windowLength
+	if i := mostRestrictive(buckets); i >= 0 {
+		b := buckets[i]
+		d.Key = keys[i]
+		d.Rate = int(math.Round(b.rate))
+		retryAfter = b.window
+		if b.fixed {
+			// Nothing is freed before the calendar window ends.
+			retryAfter = b.reset.Sub(now).Round(time.Second)
+		}
+		if i > 0 {
+			d.Limit = b.limit
+			d.Reset = b.reset
+			setHeader(w, cfg.headers.Limit, strconv.Itoa(d.Limit))
+			setHeader(w, cfg.headers.Reset, strconv.FormatInt(d.Reset.Unix(), 10))
+		}
 	}
 
-	if rate+increment > limit {
-		setHeader(w, l.headers.Remaining, strconv.Itoa(limit-rate))
+	if d.Increment > 1 {
+		setHeader(w, cfg.headers.Increment, strconv.Itoa(d.Increment))
+	}
+
+	if d.Rate+d.Increment > d.Limit {
+		if delay, ok := delayFor(buckets, now, d.Increment); ok && delay <= maxDelay {
+			l.mu.Unlock()
+			return OutcomeLimited, delay
+		}
+
+		d.Remaining = max(d.Limit-d.Rate, 0)
+		setHeader(w, cfg.headers.Remaining, strconv.Itoa(d.Remaining))
 
 		l.mu.Unlock()
-		setHeader(w, l.headers.RetryAfter, strconv.Itoa(int(l.windowLength.Seconds()))) // RFC 6585
-		return true
+		setHeader(w, cfg.headers.RetryAfter, strconv.Itoa(int(retryAfter.Seconds()))) // RFC 6585
+		d.Outcome = OutcomeLimited
+		l.report(r, d, nil)
+		return OutcomeLimited, 0
 	}
 
-	err = l.limitCounter.IncrementBy(key, currentWindow, increment)
+	err = l.incrementBuckets(buckets, d.Increment)
 	if err != nil {
 		l.mu.Unlock()
+		d.Outcome = OutcomeError
+		l.report(r, d, err)
 		l.onError(w, r, err)
-		return true
+		return OutcomeError, 0
 	}
 	l.mu.Unlock()
 
-	setHeader(w, l.headers.Remaining, strconv.Itoa(limit-rate-increment))
-	return false
+	d.Remaining = d.Limit - d.Rate - d.Increment
+	setHeader(w, cfg.headers.Remaining, strconv.Itoa(d.Remaining))
+	d.Outcome = OutcomeAllowed
+	l.report(r, d, nil)
+	return OutcomeAllowed, 0
 }
 
 // RespondOnLimit checks the rate limit for the given key and updates the response headers accordingly.
@@ -124,30 +311,200 @@ func (l *RateLimiter) OnLimit(w http.ResponseWriter, r *http.Request, key string
 // caller to halt further request processing. If the limit is not reached, it increments the request
 // count and returns false, allowing the request to proceed.
 func (l *RateLimiter) RespondOnLimit(w http.ResponseWriter, r *http.Request, key string) bool {
-	onLimit := l.OnLimit(w, r, key)
-	if onLimit {
+	return l.respond(w, r, l.onLimit(w, r, []string{key}))
+}
+
+// RespondOnLimitKeys is like RespondOnLimit, but checks the request against
+// several keys at once (see OnLimitKeys).
+func (l *RateLimiter) RespondOnLimitKeys(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	return l.respond(w, r, l.onLimit(w, r, keys))
+}
+
+// respond sends the response for a halted request, denied requests getting the
+// deny handler's.
+func (l *RateLimiter) respond(w http.ResponseWriter, r *http.Request, outcome Outcome) bool {
+	switch outcome {
+	case OutcomeAllowed, OutcomeExempt:
+		return false
+	case OutcomeDenied:
+		l.onDenied(w, r)
+	default:
 		l.onRateLimited(w, r)
 	}
-	return onLimit
+	return true
 }
 
 func (l *RateLimiter) Counter() LimitCounter {
 	return l.limitCounter
 }
 
+// Status reports whether key is within its limit, along with its current
+// rate. The limit is resolved as for requests (see WithLimitProvider), but
+// without a request context.
 func (l *RateLimiter) Status(key string) (bool, float64, error) {
-	return l.calculateRate(key, l.requestLimit)
+	b, err := l.status(context.Background(), key)
+	if err != nil {
+		return false, 0, err
+	}
+	return b.rate <= float64(b.limit), b.rate, nil
+}
+
+// status returns key's bucket, with its current rate.
+func (l *RateLimiter) status(ctx context.Context, key string) (bucket, error) {
+	now := time.Now().UTC()
+	buckets, err := l.resolveBuckets(ctx, l.config(), []string{key}, now)
+	if err != nil {
+		return bucket{}, err
+	}
+	if err := l.getRates(buckets, now); err != nil {
+		return bucket{}, err
+	}
+	return buckets[0], nil
+}
+
+// Reset forgets all requests counted for key, giving it a fresh bucket. It
+// returns an error wrapping errors.ErrUnsupported if the LimitCounter does not
+// implement LimitCounterResetter.
+func (l *RateLimiter) Reset(key string) error {
+	now := time.Now().UTC()
+	buckets, err := l.resolveBuckets(context.Background(), l.config(), []string{key}, now)
+	if err != nil {
+		return err
+	}
+	b := buckets[0]
+
+	resetter, ok := b.counter.(LimitCounterResetter)
+	if !ok {
+		return fmt.Errorf("httprate: %T cannot reset keys: %w", b.counter, errors.ErrUnsupported)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return resetter.Reset(b.key, b.currentWindow, b.currentWindow.Add(-b.window))
+}
+
+// TopKeys returns up to n of the limiter's heaviest keys in the current window,
+// heaviest first. Keys come from the limiter's heavy-hitter tracker if it has
+// one (see WithHeavyHitters), or else from the LimitCounter. It returns an
+// error wrapping errors.ErrUnsupported if there is no tracker and the
+// LimitCounter does not implement LimitCounterTopKeys.
+func (l *RateLimiter) TopKeys(n int) ([]KeyCount, error) {
+	if l.heavyHitters != nil {
+		hitters := l.heavyHitters.TopRequests(n)
+		keys := make([]KeyCount, len(hitters))
+		for i, hh := range hitters {
+			keys[i] = KeyCount{Key: l.originalKey(hh.Key), Count: hh.Count}
+		}
+		return keys, nil
+	}
+
+	top, ok := l.limitCounter.(LimitCounterTopKeys)
+	if !ok {
+		return nil, fmt.Errorf("httprate: %T cannot list keys: %w", l.limitCounter, errors.ErrUnsupported)
+	}
+
+	if l.name == "" {
+		keys, err := top.TopKeys(n, l.currentWindow(time.Now().UTC()))
+		for i := range keys {
+			keys[i].Key = l.originalKey(keys[i].Key)
+		}
+		return keys, err
+	}
+
+	// The counter may be shared with other limiters (see WithName): ask for
+	// more keys than needed, keep ours and strip the namespace.
+	counts, err := top.TopKeys(-1, l.currentWindow(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]KeyCount, 0, max(n, 0))
+	for _, kc := range counts {
+		if key, ok := strings.CutPrefix(kc.Key, l.name+":"); ok {
+			keys = append(keys, KeyCount{Key: l.originalKey(key), Count: kc.Count})
+			if len(keys) == n {
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Limit returns the limiter's request limit per window.
+func (l *RateLimiter) Limit() int {
+	return l.config().requestLimit
+}
+
+// WindowLength returns the limiter's window length.
+func (l *RateLimiter) WindowLength() time.Duration {
+	return l.config().windowLength
+}
+
+// Name returns the limiter's name set by WithName, or "" if it has none.
+func (l *RateLimiter) Name() string {
+	return l.name
+}
+
+// counterKey maps a rate-limit key to the key stored in the LimitCounter:
+// the key, hashed if a KeyHasher is set, and namespaced by the limiter's name
+// so limiters sharing a counter don't collide.
+func (l *RateLimiter) counterKey(key string) string {
+	if l.keyHasher != nil {
+		key = l.keyHasher.Hash(key)
+	}
+	if l.name == "" {
+		return key
+	}
+	return l.name + ":" + key
+}
+
+func (l *RateLimiter) counterKeys(keys []string) []string {
+	if l.name == "" && l.keyHasher == nil {
+		return keys
+	}
+	namespaced := make([]string, len(keys))
+	for i, key := range keys {
+		namespaced[i] = l.counterKey(key)
+	}
+	return namespaced
+}
+
+// originalKey maps a key stored in the LimitCounter, stripped of the limiter
+// name, back to the rate-limit key if the KeyHasher remembers it.
+func (l *RateLimiter) originalKey(key string) string {
+	if l.keyHasher != nil {
+		if original, ok := l.keyHasher.Lookup(key); ok {
+			return original
+		}
+	}
+	return key
 }
 
 func (l *RateLimiter) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key, err := l.keyFn(r)
+		// Lists that don't need the keys apply before keying the request, so
+		// e.g. exempt health checks pass even if they can't be keyed.
+		if outcome, ok := l.checkRequestLists(r); ok {
+			if !l.respond(w, r, l.listed(w, r, outcome, "")) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		keys, err := l.requestKeys(r)
 		if err != nil {
+			// No keyed denylist can match a request without keys.
+			if l.exemptRequest(r) {
+				l.listed(w, r, OutcomeExempt, "")
+				next.ServeHTTP(w, r)
+				return
+			}
+			l.report(r, Decision{Limiter: l.name, Outcome: OutcomeError}, err)
 			l.onError(w, r, err)
 			return
 		}
 
-		if l.RespondOnLimit(w, r, key) {
+		if l.respond(w, r, l.onLimit(w, r, keys)) {
 			return
 		}
 
@@ -155,30 +512,182 @@ func (l *RateLimiter) Handler(next http.Handler) http.Handler {
 	})
 }
 
-// currentWindow returns the start of the rate-limit window containing t, aligned
-// to windowOffset rather than the wall clock. When windowOffset is zero this is a
-// plain truncation. The result is always in (t-windowLength, t].
+// requestKeys returns the request's keys, one per composite key function (see
+// WithCompositeKeys) or else the limiter's key.
+func (l *RateLimiter) requestKeys(r *http.Request) ([]string, error) {
+	if len(l.compositeFns) == 0 {
+		key, err := l.keyFn(r)
+		if err != nil {
+			return nil, err
+		}
+		return []string{key}, nil
+	}
+
+	keys := make([]string, len(l.compositeFns))
+	for i, fn := range l.compositeFns {
+		key, err := fn(r)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// currentWindow returns the start of the rate-limit window containing t (see
+// limiterConfig.windowStart).
 func (l *RateLimiter) currentWindow(t time.Time) time.Time {
-	return t.Add(-l.windowOffset).Truncate(l.windowLength).Add(l.windowOffset)
+	cfg := l.config()
+	return cfg.windowStart(t, cfg.windowLength, l.start)
 }
 
-func (l *RateLimiter) calculateRate(key string, requestLimit int) (bool, float64, error) {
-	now := time.Now().UTC()
-	currentWindow := l.currentWindow(now)
-	previousWindow := currentWindow.Add(-l.windowLength)
+// getRates sets the sliding-window rate of every bucket at now. Buckets
+// sharing a LimitCounter are read in one call if it supports batching.
+func (l *RateLimiter) getRates(buckets []bucket, now time.Time) error {
+	defer l.observeLatency("get", time.Now())
+	for _, group := range groupBuckets(buckets) {
+		first := buckets[group[0]]
+		previousWindow := first.currentWindow.Add(-first.window)
+
+		if batch, ok := first.counter.(LimitCounterBatch); ok && len(group) > 1 {
+			keys := make([]string, len(group))
+			for j, i := range group {
+				keys[j] = buckets[i].key
+			}
+			currCounts, prevCounts, err := batch.GetBatch(keys, first.currentWindow, previousWindow)
+			if err != nil {
+				return err
+			}
+			for j, i := range group {
+				buckets[i].curr, buckets[i].prev = currCounts[j], prevCounts[j]
+				buckets[i].rate = slidingRate(now, &buckets[i], currCounts[j], prevCounts[j])
+			}
+			continue
+		}
 
-	currCount, prevCount, err := l.limitCounter.Get(key, currentWindow, previousWindow)
-	if err != nil {
-		return false, 0, err
+		for _, i := range group {
+			currCount, prevCount, err := first.counter.Get(buckets[i].key, first.currentWindow, previousWindow)
+			if err != nil {
+				return err
+			}
+			buckets[i].curr, buckets[i].prev = currCount, prevCount
+			buckets[i].rate = slidingRate(now, &buckets[i], currCount, prevCount)
+		}
+	}
+	return nil
+}
+
+// incrementBuckets increments every bucket by amount. Buckets sharing a
+// LimitCounter are incremented in one call if it supports batching.
+func (l *RateLimiter) incrementBuckets(buckets []bucket, amount int) error {
+	defer l.observeLatency("increment", time.Now())
+	for _, group := range groupBuckets(buckets) {
+		first := buckets[group[0]]
+
+		if batch, ok := first.counter.(LimitCounterBatch); ok && len(group) > 1 {
+			keys := make([]string, len(group))
+			for j, i := range group {
+				keys[j] = buckets[i].key
+			}
+			if err := batch.IncrementBatch(keys, first.currentWindow, amount); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, i := range group {
+			if err := first.counter.IncrementBy(buckets[i].key, first.currentWindow, amount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groupBuckets groups the indexes of buckets by window length, and so by
+// LimitCounter, in order of first appearance.
+func groupBuckets(buckets []bucket) [][]int {
+	if len(buckets) == 1 {
+		return [][]int{{0}}
+	}
+	var groups [][]int
+	index := make(map[time.Duration]int)
+	for i, b := range buckets {
+		g, ok := index[b.window]
+		if !ok {
+			g = len(groups)
+			index[b.window] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// mostRestrictive returns the index of the bucket with the fewest remaining
+// requests, or -1 if there are no buckets.
+func mostRestrictive(buckets []bucket) int {
+	index, remaining := -1, 0.0
+	for i, b := range buckets {
+		if r := float64(b.limit) - b.rate; index < 0 || r < remaining {
+			index, remaining = i, r
+		}
+	}
+	return index
+}
+
+// slidingRate weighs the previous window's count by how much of it still
+// overlaps the sliding window of b ending at now. Fixed windows only count the
+// current window.
+func slidingRate(now time.Time, b *bucket, currCount, prevCount int) float64 {
+	if b.fixed {
+		return float64(currCount)
 	}
+	diff := now.Sub(b.currentWindow)
+	return float64(prevCount)*(float64(b.window)-float64(diff))/float64(b.window) + float64(currCount)
+}
 
-	diff := now.Sub(currentWindow)
-	rate := float64(prevCount)*(float64(l.windowLength)-float64(diff))/float64(l.windowLength) + float64(currCount)
-	if rate > float64(requestLimit) {
-		return false, rate, nil
+// report hands a decision to the limiter's metrics collector and hooks.
+func (l *RateLimiter) report(r *http.Request, d Decision, err error) {
+	if l.metrics != nil {
+		l.metrics.ObserveDecision(l.name, d.Outcome)
+		if d.Outcome == OutcomeAllowed {
+			l.observeTrackedKeys()
+		}
 	}
 
-	return true, rate, nil
+	for _, hooks := range l.hooks {
+		switch d.Outcome {
+		case OutcomeAllowed, OutcomeExempt:
+			if hooks.OnAllow != nil {
+				hooks.OnAllow(r, d)
+			}
+		case OutcomeLimited, OutcomeDenied:
+			if hooks.OnLimit != nil {
+				hooks.OnLimit(r, d)
+			}
+		case OutcomeError:
+			if hooks.OnError != nil {
+				hooks.OnError(r, d, err)
+			}
+		}
+	}
+}
+
+// observeLatency records the duration of a LimitCounter call started at start.
+func (l *RateLimiter) observeLatency(op string, start time.Time) {
+	if l.metrics != nil {
+		l.metrics.ObserveCounterLatency(l.name, op, time.Since(start))
+	}
+}
+
+func (l *RateLimiter) observeTrackedKeys() {
+	if l.metrics == nil {
+		return
+	}
+	if c, ok := l.limitCounter.(interface{ Len() int }); ok {
+		l.metrics.SetTrackedKeys(l.name, c.Len())
+	}
 }
 
 func setHeader(w http.ResponseWriter, key string, value string) {
@@ -191,6 +700,10 @@ func onRateLimited(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 }
 
+func onDenied(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
 func onError(w http.ResponseWriter, r *http.Request, err error) {
 	http.Error(w, err.Error(), http.StatusPreconditionRequired)
 }
@@ -16,7 +16,8 @@ func TestCurrentWindowOffset(t *testing.T) {
 		347 * time.Millisecond,
 		windowLength - time.Nanosecond,
 	} {
-		l := &RateLimiter{windowLength: windowLength, windowOffset: offset}
+		l := &RateLimiter{}
+		l.cfg.Store(&limiterConfig{windowLength: windowLength, windowOffset: offset})
 
 		// A time sitting exactly on an offset boundary maps to itself.
 		base := time.Unix(1000, 0).UTC().Add(offset)
@@ -60,14 +61,14 @@ func TestLocalCounterWindowOffset(t *testing.T) {
 
 	// Default limiter uses the local counter and derives a sub-window offset.
 	rl := NewRateLimiter(10, windowLength)
-	if off := rl.windowOffset; off < 0 || off >= windowLength {
+	if off := rl.config().windowOffset; off < 0 || off >= windowLength {
 		t.Errorf("limiter windowOffset = %v, want [0, %v)", off, windowLength)
 	}
 
 	// A custom counter keeps wall-clock alignment (offset stays zero).
 	rl2 := NewRateLimiter(10, windowLength, WithLimitCounter(noOffsetCounter{}))
-	if rl2.windowOffset != 0 {
-		t.Errorf("limiter windowOffset with custom counter = %v, want 0", rl2.windowOffset)
+	if rl2.config().windowOffset != 0 {
+		t.Errorf("limiter windowOffset with custom counter = %v, want 0", rl2.config().windowOffset)
 	}
 }
 
@@ -81,3 +82,78 @@ func (noOffsetCounter) IncrementBy(string, time.Time, int) error { return nil }
 func (noOffsetCounter) Get(string, time.Time, time.Time) (int, int, error) {
 	return 0, 0, nil
 }
+
+func TestCalendarWindowBounds(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, paris)
+	}
+
+	tests := []struct {
+		period     CalendarPeriod
+		t          time.Time
+		start, end time.Time
+	}{
+		{period: Daily, t: at(time.May, 15, 13), start: at(time.May, 15, 0), end: at(time.May, 16, 0)},
+		// 23-hour day: DST starts on March 29, 2026.
+		{period: Daily, t: at(time.March, 29, 12), start: at(time.March, 29, 0), end: at(time.March, 30, 0)},
+		{period: Weekly, t: at(time.May, 17, 23), start: at(time.May, 11, 0), end: at(time.May, 18, 0)}, // Sunday
+		{period: Weekly, t: at(time.May, 18, 0), start: at(time.May, 18, 0), end: at(time.May, 25, 0)},  // Monday
+		{period: Monthly, t: at(time.February, 28, 23), start: at(time.February, 1, 0), end: at(time.March, 1, 0)},
+		{period: Monthly, t: at(time.December, 31, 12), start: at(time.December, 1, 0), end: time.Date(2027, time.January, 1, 0, 0, 0, 0, paris)},
+	}
+	for _, tt := range tests {
+		c := &calendarWindow{period: tt.period, loc: paris}
+		start := c.start(tt.t)
+		if !start.Equal(tt.start) || start.Location() != time.UTC {
+			t.Errorf("period %v: start(%v) = %v, want %v in UTC", tt.period, tt.t, start, tt.start)
+		}
+		if end := c.end(start); !end.Equal(tt.end) {
+			t.Errorf("period %v: end(%v) = %v, want %v", tt.period, start, end, tt.end)
+		}
+	}
+}
+
+func TestBucketDelay(t *testing.T) {
+	window := time.Minute
+	start := time.Date(2026, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		curr, prev int
+		limit      int
+		elapsed    time.Duration
+		fixed      bool
+		want       time.Duration
+		wantOK     bool
+	}{
+		{name: "allowed", curr: 1, prev: 0, limit: 2, want: 0, wantOK: true},
+		// 10*(60-e)/60 + 4 <= 9 from e = 30s.
+		{name: "previous window fading", curr: 4, prev: 10, limit: 10, elapsed: 10 * time.Second, want: 20 * time.Second, wantOK: true},
+		// Next window: 10*(60-e)/60 <= 9 from e = 6s.
+		{name: "current window full", curr: 10, prev: 0, limit: 10, elapsed: 15 * time.Second, want: 51 * time.Second, wantOK: true},
+		{name: "fixed window", curr: 10, limit: 10, elapsed: 15 * time.Second, fixed: true, want: 45 * time.Second, wantOK: true},
+		{name: "over the limit", curr: 0, limit: 0, wantOK: false},
+	}
+	for _, tt := range tests {
+		b := &bucket{
+			limit:         tt.limit,
+			window:        window,
+			currentWindow: start,
+			reset:         start.Add(window),
+			fixed:         tt.fixed,
+			curr:          tt.curr,
+			prev:          tt.prev,
+		}
+		now := start.Add(tt.elapsed)
+		b.rate = slidingRate(now, b, tt.curr, tt.prev)
+
+		got, ok := b.delay(now, 1)
+		if ok != tt.wantOK || (got-tt.want).Abs() > time.Millisecond {
+			t.Errorf("%s: delay = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -494,3 +494,94 @@ func TestRateLimitPayload(t *testing.T) {
 		}
 	}
 }
+
+func TestCompositeKeys(t *testing.T) {
+	type ctxKey string
+	const userKey ctxKey = "user"
+
+	keyByUser := func(r *http.Request) (string, error) {
+		v, _ := r.Context().Value(userKey).(string)
+		return "user:" + v, nil
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(3, time.Minute, httprate.Key("ignored"),
+		httprate.WithCompositeKeys(keyByUser, httprate.Key("*")),
+	)(h)
+
+	requests := []struct {
+		user       string
+		statusCode int
+	}{
+		{user: "alice", statusCode: 200},
+		{user: "alice", statusCode: 200},
+		{user: "bob", statusCode: 200},
+		{user: "bob", statusCode: 429}, // global bucket "*" exhausted
+		{user: "carol", statusCode: 429},
+	}
+	for i, tt := range requests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), userKey, tt.user))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if respCode := recorder.Result().StatusCode; respCode != tt.statusCode {
+			t.Errorf("resp.StatusCode(%v) = %v, want %v", i, respCode, tt.statusCode)
+		}
+	}
+}
+
+func TestOnLimitKeys(t *testing.T) {
+	rl := httprate.NewRateLimiter(2, time.Minute)
+
+	check := func(keys ...string) (bool, string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		recorder := httptest.NewRecorder()
+		limited := rl.OnLimitKeys(recorder, req, keys...)
+		return limited, recorder.Header().Get("X-RateLimit-Remaining")
+	}
+
+	if limited, remaining := check("a", "b"); limited || remaining != "1" {
+		t.Fatalf("a,b: limited=%v remaining=%v, want false 1", limited, remaining)
+	}
+	// The most restrictive bucket ("a") determines the headers.
+	if limited, remaining := check("a", "c"); limited || remaining != "0" {
+		t.Fatalf("a,c: limited=%v remaining=%v, want false 0", limited, remaining)
+	}
+	if limited, _ := check("c", "a"); !limited {
+		t.Fatal("c,a: want limited, bucket a is exhausted")
+	}
+	// A rejected request must not have incremented "c".
+	if limited, remaining := check("c"); limited || remaining != "0" {
+		t.Fatalf("c: limited=%v remaining=%v, want false 0", limited, remaining)
+	}
+}
+
+func TestWithNameSharedCounter(t *testing.T) {
+	counter := httprate.NewLocalLimitCounter(time.Minute)
+	login := httprate.NewRateLimiter(1, time.Minute, httprate.WithName("login"), httprate.WithLimitCounter(counter))
+	search := httprate.NewRateLimiter(1, time.Minute, httprate.WithName("search"), httprate.WithLimitCounter(counter))
+
+	check := func(rl *httprate.RateLimiter) (bool, string) {
+		recorder := httptest.NewRecorder()
+		limited := rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "user:1")
+		return limited, recorder.Header().Get("X-RateLimit-Policy")
+	}
+
+	if limited, policy := check(login); limited || policy != "login" {
+		t.Fatalf("login: limited=%v policy=%q, want false \"login\"", limited, policy)
+	}
+	// Same key on another limiter sharing the counter has its own bucket.
+	if limited, policy := check(search); limited || policy != "search" {
+		t.Fatalf("search: limited=%v policy=%q, want false \"search\"", limited, policy)
+	}
+	if limited, _ := check(login); !limited {
+		t.Fatal("login: want limited on 2nd request")
+	}
+
+	if _, rate, _ := login.Status("user:1"); rate != 1 {
+		t.Errorf("login.Status(user:1) rate = %v, want 1", rate)
+	}
+	if login.Name() != "login" {
+		t.Errorf("Name() = %q, want login", login.Name())
+	}
+}
@@ -20,7 +20,11 @@ func NewLocalLimitCounter(windowLength time.Duration) *localCounter {
 	}
 }
 
-var _ LimitCounter = (*localCounter)(nil)
+var (
+	_ LimitCounter         = (*localCounter)(nil)
+	_ LimitCounterBatch    = (*localCounter)(nil)
+	_ LimitCounterResetter = (*localCounter)(nil)
+)
 
 type localCounter struct {
 	windowLength     time.Duration
@@ -62,7 +66,72 @@ func (c *localCounter) Get(key string, currentWindow, previousWindow time.Time)
 	return 0, 0, nil
 }
 
+// GetBatch reads all keys under a single lock acquisition.
+func (c *localCounter) GetBatch(keys []string, currentWindow, previousWindow time.Time) ([]int, []int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	curr := make([]int, len(keys))
+	prev := make([]int, len(keys))
+
+	for i, key := range keys {
+		hkey := limitCounterKey(key)
+		if c.latestWindow == currentWindow {
+			curr[i] = c.latestCounters[hkey]
+			prev[i] = c.previousCounters[hkey]
+		} else if c.latestWindow == previousWindow {
+			prev[i] = c.latestCounters[hkey]
+		}
+	}
+
+	return curr, prev, nil
+}
+
+// IncrementBatch increments all keys under a single lock acquisition.
+func (c *localCounter) IncrementBatch(keys []string, currentWindow time.Time, amount int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(currentWindow)
+
+	for _, key := range keys {
+		c.latestCounters[limitCounterKey(key)] += amount
+	}
+
+	return nil
+}
+
+// Reset forgets key's counts in both windows.
+func (c *localCounter) Reset(key string, currentWindow, previousWindow time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hkey := limitCounterKey(key)
+	delete(c.latestCounters, hkey)
+	delete(c.previousCounters, hkey)
+
+	return nil
+}
+
+// Len returns the number of keys counted in the latest window.
+func (c *localCounter) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.latestCounters)
+}
+
+// Config sets the window length. Changing it forgets all counts, which belong
+// to windows of the previous length.
 func (c *localCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if windowLength == c.windowLength {
+		return
+	}
+	clear(c.latestCounters)
+	clear(c.previousCounters)
 	c.windowLength = windowLength
 	c.latestWindow = time.Now().UTC().Truncate(windowLength)
 }
@@ -169,3 +169,45 @@ func BenchmarkLocalCounter(b *testing.B) {
 		}
 	}
 }
+
+func TestLocalCounterBatch(t *testing.T) {
+	limitCounter := httprate.NewLocalLimitCounter(time.Minute)
+
+	currentWindow := time.Now().UTC().Truncate(time.Minute)
+	previousWindow := currentWindow.Add(-time.Minute)
+
+	keys := []string{"user:1", "org:1", "*"}
+
+	if err := limitCounter.IncrementBatch(keys, currentWindow, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := limitCounter.IncrementBy("*", currentWindow, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	curr, prev, err := limitCounter.GetBatch(keys, currentWindow, previousWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{2, 2, 5} {
+		if curr[i] != want || prev[i] != 0 {
+			t.Errorf("%q: curr, prev = %v, %v, want %v, 0", keys[i], curr[i], prev[i], want)
+		}
+	}
+
+	// Batch and single-key reads must agree.
+	for i, key := range keys {
+		c, p, _ := limitCounter.Get(key, currentWindow, previousWindow)
+		if c != curr[i] || p != prev[i] {
+			t.Errorf("%q: Get = %v, %v, GetBatch = %v, %v", key, c, p, curr[i], prev[i])
+		}
+	}
+
+	// Counts move to the previous window once the clock advances.
+	curr, prev, _ = limitCounter.GetBatch(keys, currentWindow.Add(time.Minute), currentWindow)
+	for i, want := range []int{2, 2, 5} {
+		if curr[i] != 0 || prev[i] != want {
+			t.Errorf("%q: next window curr, prev = %v, %v, want 0, %v", keys[i], curr[i], prev[i], want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package httprate
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// LogOptions configures WithLogger. The zero value is usable.
+type LogOptions struct {
+	// LimitLevel is the level rejections are logged at.
+	// Default: slog.LevelWarn
+	LimitLevel slog.Leveler
+
+	// ErrorLevel is the level KeyFunc and LimitCounter errors are logged at.
+	// Default: slog.LevelError
+	ErrorLevel slog.Leveler
+
+	// RotationLevel is the level window rotations are logged at.
+	// Default: slog.LevelDebug
+	RotationLevel slog.Leveler
+
+	// SampleRate logs only one in every SampleRate rejections, so a client
+	// hammering a limit can't flood the logs. Errors and rotations are never
+	// sampled.
+	// Default: 1 (every rejection)
+	SampleRate int
+
+	// RedactKey maps a rate-limit key to the value logged under "key", so keys
+	// such as API tokens or emails don't end up in the logs. Return the key
+	// unchanged to log it as is.
+	//
+	// The default pseudonymizes keys, it doesn't anonymize them: without a
+	// KeyHasher, the unkeyed hash of a low-entropy key such as an IP address
+	// or an email is easily recovered by hashing candidates.
+	// Default: the key's HMAC with the limiter's KeyHasher (see
+	// WithKeyHasher), or else its xxh3 hash in hex
+	RedactKey func(key string) string
+}
+
+// WithLogger logs the limiter's rejections, KeyFunc and LimitCounter errors and
+// window rotations to logger, with the limiter name, redacted key, limit and
+// rate as structured attributes:
+//
+//	r.Use(httprate.LimitBy(100, time.Minute, clientIPKey,
+//		httprate.WithName("api"),
+//		httprate.WithLogger(slog.Default(), httprate.LogOptions{SampleRate: 10}),
+//	))
+//
+// Logging is implemented with decision hooks (see WithDecisionHooks) and never
+// affects the response.
+func WithLogger(logger *slog.Logger, opts LogOptions) Option {
+	return func(rl *RateLimiter) {
+		if opts.LimitLevel == nil {
+			opts.LimitLevel = slog.LevelWarn
+		}
+		if opts.ErrorLevel == nil {
+			opts.ErrorLevel = slog.LevelError
+		}
+		if opts.RotationLevel == nil {
+			opts.RotationLevel = slog.LevelDebug
+		}
+		if opts.SampleRate < 1 {
+			opts.SampleRate = 1
+		}
+		dl := &decisionLogger{logger: logger, opts: opts}
+		rl.logger = dl
+		rl.hooks = append(rl.hooks, DecisionHooks{
+			OnLimit: dl.onLimit,
+			OnError: dl.onError,
+		})
+	}
+}
+
+type decisionLogger struct {
+	logger   *slog.Logger
+	opts     LogOptions
+	hasher   *KeyHasher // the limiter's, see redact
+	rejected atomic.Uint64
+	window   atomic.Int64 // start of the latest window seen, in Unix nanoseconds
+}
+
+func (dl *decisionLogger) onLimit(r *http.Request, d Decision) {
+	if (dl.rejected.Add(1)-1)%uint64(dl.opts.SampleRate) != 0 {
+		return
+	}
+	msg := "httprate: request rate-limited"
+	if d.Outcome == OutcomeDenied {
+		msg = "httprate: request denied"
+	}
+	dl.logger.LogAttrs(r.Context(), dl.opts.LimitLevel.Level(), msg, dl.decisionAttrs(d)...)
+}
+
+func (dl *decisionLogger) onError(r *http.Request, d Decision, err error) {
+	attrs := append(dl.decisionAttrs(d), slog.String("error", err.Error()))
+	dl.logger.LogAttrs(r.Context(), dl.opts.ErrorLevel.Level(), "httprate: rate-limit check failed", attrs...)
+}
+
+// rotate logs when the limiter sees a new window for the first time.
+func (dl *decisionLogger) rotate(ctx context.Context, limiter string, window time.Time, windowLength time.Duration) {
+	start := window.UnixNano()
+	prev := dl.window.Load()
+	if prev >= start || !dl.window.CompareAndSwap(prev, start) {
+		return
+	}
+	if prev == 0 {
+		// First request, not a rotation.
+		return
+	}
+	dl.logger.LogAttrs(ctx, dl.opts.RotationLevel.Level(), "httprate: window rotated",
+		slog.String("limiter", limiter),
+		slog.Time("window", window),
+		slog.Duration("window_length", windowLength),
+	)
+}
+
+func (dl *decisionLogger) decisionAttrs(d Decision) []slog.Attr {
+	attrs := []slog.Attr{slog.String("limiter", d.Limiter)}
+	if d.Key != "" {
+		attrs = append(attrs, slog.String("key", dl.redact(d.Key)))
+	}
+	if d.Limit > 0 {
+		attrs = append(attrs,
+			slog.Int("limit", d.Limit),
+			slog.Int("rate", d.Rate),
+			slog.Int("increment", d.Increment),
+		)
+	}
+	return attrs
+}
+
+// redact returns the value logged for key (see LogOptions.RedactKey).
+func (dl *decisionLogger) redact(key string) string {
+	switch {
+	case dl.opts.RedactKey != nil:
+		return dl.opts.RedactKey(key)
+	case dl.hasher != nil:
+		return dl.hasher.Hash(key)
+	default:
+		return strconv.FormatUint(xxh3.HashString(key), 16)
+	}
+}
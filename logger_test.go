@@ -0,0 +1,114 @@
+package httprate_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(1, time.Minute, httprate.Key("secret-token"),
+		httprate.WithName("api"),
+		httprate.WithLogger(logger, httprate.LogOptions{SampleRate: 2}),
+	)(h)
+
+	for range 5 {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	// 4 rejections sampled 1 in 2.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %v log lines, want 2:\n%s", len(lines), buf.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "WARN" || entry["limiter"] != "api" || entry["limit"] != 1.0 || entry["rate"] != 1.0 {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+	if key, _ := entry["key"].(string); key == "" || strings.Contains(key, "secret-token") {
+		t.Errorf("key = %q, want redacted hash", key)
+	}
+}
+
+func TestWithLoggerKeyHasher(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	hasher := httprate.NewKeyHasher([]byte("secret"), 0)
+
+	rl := httprate.NewRateLimiter(1, time.Minute,
+		httprate.WithLogger(logger, httprate.LogOptions{}),
+		httprate.WithKeyHasher(hasher),
+	)
+	for range 2 {
+		rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "alice@example.com")
+	}
+
+	// Logged keys are keyed hashes, as in the LimitCounter.
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if want := hasher.Hash("alice@example.com"); entry["key"] != want {
+		t.Errorf("key = %v, want %v", entry["key"], want)
+	}
+}
+
+func TestWithLoggerErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(1, time.Minute,
+		func(r *http.Request) (string, error) { return "", errors.New("no tenant") },
+		httprate.WithLogger(logger, httprate.LogOptions{
+			ErrorLevel: slog.LevelWarn,
+			RedactKey:  func(key string) string { return key },
+		}),
+	)(h)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %q", err, buf.String())
+	}
+	if entry["level"] != "WARN" || entry["error"] != "no tenant" {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+}
+
+func TestWithLoggerRotation(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(10, 50*time.Millisecond, httprate.Key("*"),
+		httprate.WithLogger(logger, httprate.LogOptions{RotationLevel: slog.LevelInfo}),
+	)(h)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if buf.Len() != 0 {
+		t.Fatalf("first request logged a rotation: %s", buf.String())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(buf.String(), `"msg":"httprate: window rotated"`) {
+		t.Errorf("want window rotation logged, got: %s", buf.String())
+	}
+}
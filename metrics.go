@@ -0,0 +1,205 @@
+package httprate
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector receives metrics from a RateLimiter (see WithMetrics).
+// Implement it to wire httprate into your own metrics library, or use
+// NewMetrics for a dependency-free collector serving the Prometheus text
+// format. Limiters are identified by their name (see WithName).
+//
+// Methods are called on the request path and must be safe for concurrent use.
+type MetricsCollector interface {
+	// ObserveDecision counts a request allowed, limited or failed by a limiter.
+	ObserveDecision(limiter string, outcome Outcome)
+
+	// ObserveCounterLatency records the duration of a LimitCounter call. The
+	// operation is either "get" or "increment".
+	ObserveCounterLatency(limiter string, op string, d time.Duration)
+
+	// SetTrackedKeys reports how many keys the limiter's LimitCounter holds in
+	// the current window. It is only called for counters implementing
+	// interface{ Len() int }, such as NewLocalLimitCounter.
+	SetTrackedKeys(limiter string, n int)
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the counter
+// latency histogram buckets used by NewMetrics.
+var DefaultLatencyBuckets = []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// NewMetrics creates a MetricsCollector that keeps its metrics in memory and
+// serves them in the Prometheus text exposition format, so they can be scraped
+// without pulling in a Prometheus client library:
+//
+//	metrics := httprate.NewMetrics()
+//	r.Use(httprate.LimitBy(100, time.Minute, clientIPKey,
+//		httprate.WithName("api"), httprate.WithMetrics(metrics)))
+//	r.Handle("/metrics", metrics)
+//
+// It exposes:
+//
+//	httprate_decisions_total{limiter, outcome}           counter
+//	httprate_counter_duration_seconds{limiter, op}       histogram
+//	httprate_tracked_keys{limiter}                       gauge
+//	httprate_adaptive_limit{limiter}                     gauge, see NewAdaptiveLimit
+//
+// A single Metrics can be shared by any number of limiters.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:     DefaultLatencyBuckets,
+		decisions:   make(map[decisionLabels]uint64),
+		latencies:   make(map[latencyLabels]*histogram),
+		trackedKeys: make(map[string]int),
+		adaptive:    make(map[string]int),
+	}
+}
+
+var (
+	_ MetricsCollector         = (*Metrics)(nil)
+	_ AdaptiveMetricsCollector = (*Metrics)(nil)
+	_ http.Handler             = (*Metrics)(nil)
+)
+
+// Metrics is the in-memory MetricsCollector returned by NewMetrics.
+type Metrics struct {
+	buckets     []float64
+	decisions   map[decisionLabels]uint64
+	latencies   map[latencyLabels]*histogram
+	trackedKeys map[string]int
+	adaptive    map[string]int
+	mu          sync.Mutex
+}
+
+type decisionLabels struct {
+	limiter string
+	outcome Outcome
+}
+
+type latencyLabels struct {
+	limiter string
+	op      string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (m *Metrics) ObserveDecision(limiter string, outcome Outcome) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.decisions[decisionLabels{limiter, outcome}]++
+}
+
+func (m *Metrics) ObserveCounterLatency(limiter string, op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.latencies[latencyLabels{limiter, op}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[latencyLabels{limiter, op}] = h
+	}
+
+	seconds := d.Seconds()
+	if i, _ := slices.BinarySearch(m.buckets, seconds); i < len(m.buckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) SetTrackedKeys(limiter string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trackedKeys[limiter] = n
+}
+
+func (m *Metrics) SetAdaptiveLimit(name string, limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.adaptive[name] = limit
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(m.String()))
+}
+
+// String returns all metrics in the Prometheus text exposition format.
+func (m *Metrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP httprate_decisions_total Rate-limit decisions by limiter and outcome.\n")
+	b.WriteString("# TYPE httprate_decisions_total counter\n")
+	decisions := sortedKeys(m.decisions, func(a, b decisionLabels) int {
+		return strings.Compare(a.limiter+"\x00"+string(a.outcome), b.limiter+"\x00"+string(b.outcome))
+	})
+	for _, l := range decisions {
+		fmt.Fprintf(&b, "httprate_decisions_total{limiter=%s,outcome=%s} %d\n", quoteLabel(l.limiter), quoteLabel(string(l.outcome)), m.decisions[l])
+	}
+
+	b.WriteString("# HELP httprate_counter_duration_seconds Latency of LimitCounter calls.\n")
+	b.WriteString("# TYPE httprate_counter_duration_seconds histogram\n")
+	latencies := sortedKeys(m.latencies, func(a, b latencyLabels) int {
+		return strings.Compare(a.limiter+"\x00"+a.op, b.limiter+"\x00"+b.op)
+	})
+	for _, l := range latencies {
+		h := m.latencies[l]
+		labels := "limiter=" + quoteLabel(l.limiter) + ",op=" + quoteLabel(l.op)
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "httprate_counter_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "httprate_counter_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "httprate_counter_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "httprate_counter_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP httprate_tracked_keys Keys held by the limiter's counter in the current window.\n")
+	b.WriteString("# TYPE httprate_tracked_keys gauge\n")
+	for _, limiter := range sortedKeys(m.trackedKeys, strings.Compare) {
+		fmt.Fprintf(&b, "httprate_tracked_keys{limiter=%s} %d\n", quoteLabel(limiter), m.trackedKeys[limiter])
+	}
+
+	if len(m.adaptive) > 0 {
+		b.WriteString("# HELP httprate_adaptive_limit Current adaptive limit.\n")
+		b.WriteString("# TYPE httprate_adaptive_limit gauge\n")
+		for _, name := range sortedKeys(m.adaptive, strings.Compare) {
+			fmt.Fprintf(&b, "httprate_adaptive_limit{limiter=%s} %d\n", quoteLabel(name), m.adaptive[name])
+		}
+	}
+
+	return b.String()
+}
+
+func sortedKeys[K comparable, V any](m map[K]V, cmp func(a, b K) int) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+// quoteLabel quotes a label value per the Prometheus text format, which only
+// escapes backslash, double-quote and line feed.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
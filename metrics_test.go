@@ -0,0 +1,58 @@
+package httprate_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := httprate.NewMetrics()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(2, time.Minute, httprate.KeyByEndpoint,
+		httprate.WithName("api"),
+		httprate.WithMetrics(metrics),
+	)(h)
+
+	for _, path := range []string{"/a", "/a", "/a", "/b"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Result().Body)
+
+	if ct := recorder.Result().Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want Prometheus text format", ct)
+	}
+
+	for _, want := range []string{
+		`# TYPE httprate_decisions_total counter`,
+		`httprate_decisions_total{limiter="api",outcome="allowed"} 3`,
+		`httprate_decisions_total{limiter="api",outcome="limited"} 1`,
+		`# TYPE httprate_counter_duration_seconds histogram`,
+		`httprate_counter_duration_seconds_bucket{limiter="api",op="get",le="+Inf"} 4`,
+		`httprate_counter_duration_seconds_count{limiter="api",op="increment"} 3`,
+		`httprate_tracked_keys{limiter="api"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	metrics := httprate.NewMetrics()
+	metrics.ObserveDecision("a\"b\\c\nd", httprate.OutcomeError)
+
+	want := `httprate_decisions_total{limiter="a\"b\\c\nd",outcome="error"} 1`
+	if got := metrics.String(); !strings.Contains(got, want) {
+		t.Errorf("metrics missing %q\n%s", want, got)
+	}
+}
@@ -0,0 +1,654 @@
+package httprate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy is a declarative description of rate limiters and the routes they
+// apply to, typically loaded from a JSON or YAML file with ParsePolicy:
+//
+//	limiters:
+//	  - name: api
+//	    limit: 100
+//	    window: 1m
+//	    keys: [ip, endpoint]
+//	    exempt:
+//	      ips: [10.0.0.0/8]
+//	  - name: login
+//	    limit: 5
+//	    window: 1m
+//	    keys: [ip]
+//	    headers:
+//	      limit: RateLimit-Limit
+//	      remaining: ""          # omitted
+//	routes:
+//	  - pattern: /api/
+//	    limiters: [api]
+//	  - pattern: POST /login
+//	    limiters: [api, login]
+type Policy struct {
+	Limiters []LimiterPolicy `json:"limiters" yaml:"limiters"`
+	Routes   []RoutePolicy   `json:"routes" yaml:"routes"`
+}
+
+// LimiterPolicy describes a named RateLimiter.
+type LimiterPolicy struct {
+	Name   string   `json:"name" yaml:"name"`
+	Limit  int      `json:"limit" yaml:"limit"`
+	Window Duration `json:"window" yaml:"window"`
+
+	// Algorithm is the rate-limiting algorithm. Only "sliding-window", the
+	// default, is supported.
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+
+	// Keys are the KeyFuncs keying requests, joined into one key (see
+	// JoinKeys), or counted as separate buckets if Composite is set (see
+	// WithCompositeKeys). Without keys, all requests share one bucket.
+	//
+	// Built-in keys are "ip" (the client IP, see PolicyOptions.ClientIP),
+	// "route" or "pattern" (the pattern of the RoutePolicy matching the
+	// request), "endpoint", "method", "header:<name>", "cookie:<name>" and
+	// "query:<param>" (see KeyByEndpoint, KeyByMethod, KeyByHeader,
+	// KeyByCookie and KeyByQuery). PolicyOptions.KeyFuncs adds more.
+	Keys      []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Composite bool     `json:"composite,omitempty" yaml:"composite,omitempty"`
+
+	// Exempt and Deny list the clients exempt from the limiter or denied
+	// access (see WithExemptIPs, WithExemptKeys, WithDenyIPs and WithDenyKeys).
+	Exempt *ListPolicy `json:"exempt,omitempty" yaml:"exempt,omitempty"`
+	Deny   *ListPolicy `json:"deny,omitempty" yaml:"deny,omitempty"`
+
+	// Headers overrides the response headers by field of ResponseHeaders:
+	// "limit", "remaining", "increment", "reset", "retry-after", "policy" and
+	// "exempt". An empty name omits the header.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// ListPolicy is an exemption list or a denylist.
+type ListPolicy struct {
+	IPs  []string `json:"ips,omitempty" yaml:"ips,omitempty"`
+	Keys []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+}
+
+// RoutePolicy applies limiters, in order, to the requests matching a
+// net/http ServeMux pattern, e.g. "/api/" or "POST /login". Requests matching
+// no route are not rate-limited.
+type RoutePolicy struct {
+	Pattern  string   `json:"pattern" yaml:"pattern"`
+	Limiters []string `json:"limiters" yaml:"limiters"`
+}
+
+// Duration is a time.Duration written as a string such as "1m30s" in policy
+// files.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ParsePolicy parses and validates a policy. unmarshal decodes data, e.g.
+// yaml.Unmarshal from gopkg.in/yaml.v3 for YAML; if nil, data is decoded as
+// JSON. Unknown fields are rejected either way, so typos don't go unnoticed.
+func ParsePolicy(data []byte, unmarshal func(data []byte, v any) error) (*Policy, error) {
+	var p Policy
+	if unmarshal == nil {
+		if err := unmarshalJSONStrict(data, &p); err != nil {
+			return nil, fmt.Errorf("httprate: policy: %w", err)
+		}
+	} else {
+		if err := unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("httprate: policy: %w", err)
+		}
+		if err := rejectUnknownFields(data, unmarshal); err != nil {
+			return nil, fmt.Errorf("httprate: policy: %w", err)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LoadPolicy reads and parses the policy file at path (see ParsePolicy).
+func LoadPolicy(path string, unmarshal func(data []byte, v any) error) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("httprate: policy: %w", err)
+	}
+	return ParsePolicy(data, unmarshal)
+}
+
+func unmarshalJSONStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// rejectUnknownFields checks that data only has Policy's fields, as
+// unmarshalJSONStrict does, for decoders ignoring unknown fields such as
+// yaml.Unmarshal: it decodes data into generic values with unmarshal, and
+// their JSON encoding strictly into a Policy.
+func rejectUnknownFields(data []byte, unmarshal func(data []byte, v any) error) error {
+	var raw any
+	if err := unmarshal(data, &raw); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(jsonValue(raw))
+	if err != nil {
+		return err
+	}
+	return unmarshalJSONStrict(encoded, new(Policy))
+}
+
+// jsonValue converts the maps with non-string keys some decoders return, e.g.
+// gopkg.in/yaml.v2, to maps JSON can encode.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	}
+	return v
+}
+
+// Validate checks the policy, returning all the problems found, joined. Key
+// names are checked by Build, which knows the custom ones.
+func (p *Policy) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("httprate: policy: "+format, args...))
+	}
+
+	names := make(map[string]bool, len(p.Limiters))
+	for i, lp := range p.Limiters {
+		at := fmt.Sprintf("limiters[%d]", i)
+		switch {
+		case lp.Name == "":
+			fail("%s.name: required", at)
+		case names[lp.Name]:
+			fail("%s.name: duplicate limiter %q", at, lp.Name)
+		}
+		names[lp.Name] = true
+
+		if lp.Limit <= 0 {
+			fail("%s.limit: must be positive, got %d", at, lp.Limit)
+		}
+		if lp.Window <= 0 {
+			fail("%s.window: must be positive, got %v", at, time.Duration(lp.Window))
+		}
+		if lp.Algorithm != "" && lp.Algorithm != "sliding-window" {
+			fail("%s.algorithm: unsupported algorithm %q", at, lp.Algorithm)
+		}
+		for j, key := range lp.Keys {
+			if key == "" {
+				fail("%s.keys[%d]: empty key", at, j)
+			}
+		}
+		for _, list := range lp.lists() {
+			if list.policy == nil {
+				continue
+			}
+			for j, cidr := range list.policy.IPs {
+				if _, err := parsePrefix(cidr); err != nil {
+					fail("%s.%s.ips[%d]: %w", at, list.name, j, err)
+				}
+			}
+		}
+		for _, field := range sortedKeys(lp.Headers, strings.Compare) {
+			if _, ok := headerFields[field]; !ok {
+				fail("%s.headers: unknown header %q", at, field)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	for i, rp := range p.Routes {
+		at := fmt.Sprintf("routes[%d]", i)
+		if err := handlePattern(mux, rp.Pattern, http.NotFoundHandler()); err != nil {
+			fail("%s.pattern: %w", at, err)
+		}
+		if len(rp.Limiters) == 0 {
+			fail("%s.limiters: required", at)
+		}
+		for j, name := range rp.Limiters {
+			if !names[name] {
+				fail("%s.limiters[%d]: unknown limiter %q", at, j, name)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// headerFields maps policy header names to the fields of ResponseHeaders.
+var headerFields = map[string]func(h *ResponseHeaders) *string{
+	"limit":       func(h *ResponseHeaders) *string { return &h.Limit },
+	"remaining":   func(h *ResponseHeaders) *string { return &h.Remaining },
+	"increment":   func(h *ResponseHeaders) *string { return &h.Increment },
+	"reset":       func(h *ResponseHeaders) *string { return &h.Reset },
+	"retry-after": func(h *ResponseHeaders) *string { return &h.RetryAfter },
+	"policy":      func(h *ResponseHeaders) *string { return &h.Policy },
+	"exempt":      func(h *ResponseHeaders) *string { return &h.Exempt },
+}
+
+// handlePattern registers h for pattern, returning an error instead of
+// panicking on invalid or conflicting patterns.
+func handlePattern(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	if pattern == "" {
+		return errors.New("required")
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+// PolicyOptions configures the RateLimiters built from a Policy.
+type PolicyOptions struct {
+	// ClientIP returns the client IP of a request, for the "ip" key and IP
+	// lists. httprate does not resolve it itself (see CanonicalizeIP); policies
+	// using IPs fail to build without it.
+	ClientIP func(r *http.Request) string
+
+	// KeyFuncs are custom keys, by name, usable in LimiterPolicy.Keys.
+	KeyFuncs map[string]KeyFunc
+
+	// Options are applied to every limiter, before the policy's, e.g.
+	// WithMetrics or WithLimitCounterFactory.
+	Options []Option
+
+	// OnReload is called by WatchPolicy after every reload, with the error if
+	// the policy could not be loaded, in which case the previous one is kept.
+	OnReload func(err error)
+}
+
+// Build creates the RateLimiters and routes of the policy.
+func (p *Policy) Build(opts PolicyOptions) (*PolicySet, error) {
+	return p.build(opts, nil)
+}
+
+// PolicySet is the set of RateLimiters and routes built from a Policy.
+type PolicySet struct {
+	policy   *Policy
+	limiters map[string]*RateLimiter
+	mux      *http.ServeMux
+}
+
+// policyRoute is the ServeMux handler of a route, only used to match requests.
+type policyRoute struct {
+	limiters []*RateLimiter
+}
+
+func (*policyRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+// build builds the policy, reusing the limiters of prev whose keys and lists
+// didn't change, so they keep counting.
+func (p *Policy) build(opts PolicyOptions, prev *PolicySet) (*PolicySet, error) {
+	set := &PolicySet{
+		policy:   p,
+		limiters: make(map[string]*RateLimiter, len(p.Limiters)),
+		mux:      http.NewServeMux(),
+	}
+
+	// The limiters reused from prev are only updated once the whole set
+	// built, so a policy failing to build leaves prev unchanged.
+	var updates []func()
+	var errs []error
+	for i, lp := range p.Limiters {
+		headers := defaultResponseHeaders
+		for field, name := range lp.Headers {
+			*headerFields[field](&headers) = name
+		}
+
+		if l := prev.reusable(lp); l != nil {
+			if err := l.checkWindow(time.Duration(lp.Window)); err != nil {
+				errs = append(errs, fmt.Errorf("httprate: policy: limiters[%d]: %w", i, err))
+				continue
+			}
+			updates = append(updates, func() {
+				// Checked above, and the limit is validated by Validate.
+				l.SetWindow(time.Duration(lp.Window))
+				l.SetLimit(lp.Limit)
+				l.SetHeaders(headers)
+			})
+			set.limiters[lp.Name] = l
+			continue
+		}
+
+		options, err := lp.options(opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("httprate: policy: limiters[%d]: %w", i, err))
+			continue
+		}
+		options = append(options, WithName(lp.Name), WithResponseHeaders(headers))
+		set.limiters[lp.Name] = NewRateLimiter(lp.Limit, time.Duration(lp.Window), options...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	for i, rp := range p.Routes {
+		route := &policyRoute{}
+		for _, name := range rp.Limiters {
+			route.limiters = append(route.limiters, set.limiters[name])
+		}
+		if err := handlePattern(set.mux, rp.Pattern, route); err != nil {
+			return nil, fmt.Errorf("httprate: policy: routes[%d].pattern: %w", i, err)
+		}
+	}
+
+	for _, update := range updates {
+		update()
+	}
+	return set, nil
+}
+
+// reusable returns the limiter of s for lp, if it only differs from lp by its
+// limit, window or headers.
+func (s *PolicySet) reusable(lp LimiterPolicy) *RateLimiter {
+	if s == nil {
+		return nil
+	}
+	l, ok := s.limiters[lp.Name]
+	if !ok {
+		return nil
+	}
+	for _, old := range s.policy.Limiters {
+		if old.Name == lp.Name {
+			old.Limit, old.Window, old.Headers = 0, 0, nil
+			lp.Limit, lp.Window, lp.Headers = 0, 0, nil
+			if reflect.DeepEqual(old, lp) {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// options returns the Options of the limiter, without its name and headers.
+func (lp LimiterPolicy) options(opts PolicyOptions) ([]Option, error) {
+	options := append([]Option(nil), opts.Options...)
+
+	var errs []error
+	keyFns := make([]KeyFunc, 0, len(lp.Keys))
+	for j, key := range lp.Keys {
+		keyFn, err := opts.keyFunc(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keys[%d]: %w", j, err))
+			continue
+		}
+		keyFns = append(keyFns, keyFn)
+	}
+	switch {
+	case lp.Composite && len(keyFns) > 0:
+		options = append(options, WithCompositeKeys(keyFns...))
+	case len(keyFns) > 0:
+		options = append(options, WithKeyFuncs(keyFns...))
+	}
+
+	for _, list := range lp.lists() {
+		if list.policy == nil {
+			continue
+		}
+		if len(list.policy.Keys) > 0 {
+			options = append(options, list.keys(list.policy.Keys...))
+		}
+		if len(list.policy.IPs) > 0 {
+			if opts.ClientIP == nil {
+				errs = append(errs, fmt.Errorf("%s.ips: PolicyOptions.ClientIP is required", list.name))
+				continue
+			}
+			ips, err := NewIPSet(list.policy.IPs...)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.ips: %w", list.name, err))
+				continue
+			}
+			options = append(options, list.ips(opts.ClientIP, ips))
+		}
+	}
+
+	return options, errors.Join(errs...)
+}
+
+type policyList struct {
+	name   string
+	policy *ListPolicy
+	keys   func(keys ...string) Option
+	ips    func(ipFn func(r *http.Request) string, ips *IPSet) Option
+}
+
+func (lp LimiterPolicy) lists() []policyList {
+	return []policyList{
+		{name: "exempt", policy: lp.Exempt, keys: WithExemptKeys, ips: WithExemptIPs},
+		{name: "deny", policy: lp.Deny, keys: WithDenyKeys, ips: WithDenyIPs},
+	}
+}
+
+// keyFunc returns the KeyFunc named key (see LimiterPolicy.Keys).
+func (opts PolicyOptions) keyFunc(key string) (KeyFunc, error) {
+	if keyFn, ok := opts.KeyFuncs[key]; ok {
+		return keyFn, nil
+	}
+
+	kind, arg, hasArg := strings.Cut(key, ":")
+	switch {
+	case key == "ip":
+		if opts.ClientIP == nil {
+			return nil, errors.New(`key "ip": PolicyOptions.ClientIP is required`)
+		}
+		return func(r *http.Request) (string, error) {
+			return CanonicalizeIP(opts.ClientIP(r)), nil
+		}, nil
+	case key == "endpoint":
+		return KeyByEndpoint, nil
+	case key == "route" || key == "pattern":
+		// r.Pattern is only set once the application's router ran, after
+		// the limiters: key by the pattern the PolicySet matched instead.
+		return func(r *http.Request) (string, error) {
+			return getPolicyPattern(r.Context()), nil
+		}, nil
+	case key == "method":
+		return KeyByMethod, nil
+	case hasArg && arg != "" && kind == "header":
+		return KeyByHeader(arg), nil
+	case hasArg && arg != "" && kind == "cookie":
+		return KeyByCookie(arg), nil
+	case hasArg && arg != "" && kind == "query":
+		return KeyByQuery(arg), nil
+	}
+	return nil, fmt.Errorf("unknown key %q", key)
+}
+
+// Limiter returns the limiter with the given name, or nil.
+func (s *PolicySet) Limiter(name string) *RateLimiter {
+	return s.limiters[name]
+}
+
+// Limiters returns all the limiters of the set, e.g. to register them with
+// an admin handler (see NewAdminHandler).
+func (s *PolicySet) Limiters() []*RateLimiter {
+	limiters := make([]*RateLimiter, 0, len(s.policy.Limiters))
+	for _, lp := range s.policy.Limiters {
+		limiters = append(limiters, s.limiters[lp.Name])
+	}
+	return limiters
+}
+
+// Handler is a middleware applying the limiters of the route matching each
+// request.
+func (s *PolicySet) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, next)
+	})
+}
+
+func (s *PolicySet) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	route, pattern, ok := s.route(r)
+	if !ok {
+		next.ServeHTTP(w, r)
+		return
+	}
+	h := next
+	for i := len(route.limiters) - 1; i >= 0; i-- {
+		h = route.limiters[i].Handler(h)
+	}
+	h.ServeHTTP(w, r.WithContext(withPolicyPattern(r.Context(), pattern)))
+}
+
+// route returns the route matching r, and its pattern.
+func (s *PolicySet) route(r *http.Request) (*policyRoute, string, bool) {
+	h, pattern := s.mux.Handler(r)
+	route, ok := h.(*policyRoute)
+	return route, pattern, ok
+}
+
+// WatchPolicy loads the policy file at path (see LoadPolicy) and reloads it
+// every interval if it changed. A policy failing to load or build is reported
+// to opts.OnReload and ignored, keeping the previous one. Limiters whose keys
+// and lists didn't change keep their counts across reloads, with their new
+// limit, window and headers (see RateLimiter.SetLimit).
+//
+//	watcher, err := httprate.WatchPolicy("ratelimit.yaml", yaml.Unmarshal, httprate.PolicyOptions{
+//		ClientIP: func(r *http.Request) string { return middleware.GetClientIP(r.Context()) },
+//		OnReload: func(err error) {
+//			if err != nil {
+//				slog.Error("rate-limit policy", "error", err)
+//			}
+//		},
+//	}, 10*time.Second)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer watcher.Close()
+//	r.Use(watcher.Handler)
+func WatchPolicy(path string, unmarshal func(data []byte, v any) error, opts PolicyOptions, interval time.Duration) (*PolicyWatcher, error) {
+	pw := &PolicyWatcher{
+		path:      path,
+		unmarshal: unmarshal,
+		opts:      opts,
+		done:      make(chan struct{}),
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("httprate: policy: %w", err)
+	}
+	if err := pw.load(stat); err != nil {
+		return nil, err
+	}
+
+	go pw.watch(interval)
+	return pw, nil
+}
+
+// PolicyWatcher is the watcher returned by WatchPolicy.
+type PolicyWatcher struct {
+	path      string
+	unmarshal func(data []byte, v any) error
+	opts      PolicyOptions
+	set       atomic.Pointer[PolicySet]
+	modTime   time.Time
+	size      int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Current returns the policy set currently in effect.
+func (pw *PolicyWatcher) Current() *PolicySet {
+	return pw.set.Load()
+}
+
+// Handler is a middleware applying the current policy (see PolicySet.Handler).
+func (pw *PolicyWatcher) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw.Current().serve(w, r, next)
+	})
+}
+
+// Close stops watching the policy file. The current policy stays in effect.
+func (pw *PolicyWatcher) Close() {
+	pw.closeOnce.Do(func() { close(pw.done) })
+}
+
+func (pw *PolicyWatcher) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.done:
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(pw.path)
+		if err != nil {
+			pw.reloaded(fmt.Errorf("httprate: policy: %w", err))
+			continue
+		}
+		if stat.ModTime().Equal(pw.modTime) && stat.Size() == pw.size {
+			continue
+		}
+		pw.reloaded(pw.load(stat))
+	}
+}
+
+func (pw *PolicyWatcher) load(stat os.FileInfo) error {
+	// Remember the file even if it is invalid, so it isn't reloaded until it
+	// changes again.
+	pw.modTime, pw.size = stat.ModTime(), stat.Size()
+
+	p, err := LoadPolicy(pw.path, pw.unmarshal)
+	if err != nil {
+		return err
+	}
+	set, err := p.build(pw.opts, pw.set.Load())
+	if err != nil {
+		return err
+	}
+	pw.set.Store(set)
+	return nil
+}
+
+func (pw *PolicyWatcher) reloaded(err error) {
+	if pw.opts.OnReload != nil {
+		pw.opts.OnReload(err)
+	}
+}
@@ -0,0 +1,264 @@
+package httprate_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+const testPolicy = `{
+	"limiters": [
+		{"name": "api", "limit": 3, "window": "1m", "keys": ["ip"], "exempt": {"ips": ["10.0.0.0/8"]}},
+		{"name": "login", "limit": 1, "window": "1m", "keys": ["ip", "header:X-User"],
+		 "headers": {"limit": "RateLimit-Limit", "remaining": ""}}
+	],
+	"routes": [
+		{"pattern": "/api/", "limiters": ["api"]},
+		{"pattern": "POST /login", "limiters": ["api", "login"]},
+		{"pattern": "/logout", "limiters": ["login"]}
+	]
+}`
+
+func policyOptions() httprate.PolicyOptions {
+	return httprate.PolicyOptions{
+		ClientIP: func(r *http.Request) string { return r.Header.Get("X-Test-IP") },
+	}
+}
+
+func policyRequest(h http.Handler, method, path, ip string) *http.Response {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Test-IP", ip)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := httprate.ParsePolicy([]byte(testPolicy), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := p.Build(policyOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Limiters()) != 2 || set.Limiter("login").WindowLength() != time.Minute {
+		t.Fatalf("Limiters() = %v", set.Limiters())
+	}
+
+	h := set.Handler(okHandler())
+	codes := func(method, path, ip string, n int) []int {
+		var codes []int
+		for range n {
+			codes = append(codes, policyRequest(h, method, path, ip).StatusCode)
+		}
+		return codes
+	}
+
+	wantCodes(t, codes("GET", "/api/users", "192.0.2.1", 4), []int{200, 200, 200, 429})
+	wantCodes(t, codes("GET", "/api/users", "10.1.2.3", 4), []int{200, 200, 200, 200}) // exempt
+	wantCodes(t, codes("GET", "/other", "192.0.2.1", 2), []int{200, 200})              // no route
+	wantCodes(t, codes("POST", "/login", "192.0.2.2", 2), []int{200, 429})
+
+	resp := policyRequest(h, "GET", "/logout", "192.0.2.3")
+	if resp.Header.Get("RateLimit-Limit") != "1" || resp.Header.Get("X-RateLimit-Remaining") != "" {
+		t.Errorf("logout headers = %v, want RateLimit-Limit and no X-RateLimit-Remaining", resp.Header)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	_, err := httprate.ParsePolicy([]byte(`{
+		"limiters": [
+			{"name": "api", "limit": 0, "window": "1m", "algorithm": "token-bucket"},
+			{"name": "api", "limit": 1, "window": "0s", "exempt": {"ips": ["10.0.0.0/33"]}, "headers": {"limt": "X"}}
+		],
+		"routes": [
+			{"pattern": "/api/", "limiters": ["api", "nope"]},
+			{"pattern": "/api/", "limiters": ["api"]},
+			{"pattern": "", "limiters": []}
+		]
+	}`), nil)
+	if err == nil {
+		t.Fatal("want validation errors")
+	}
+	for _, want := range []string{
+		"limiters[0].limit: must be positive",
+		`limiters[0].algorithm: unsupported algorithm "token-bucket"`,
+		`limiters[1].name: duplicate limiter "api"`,
+		"limiters[1].window: must be positive",
+		"limiters[1].exempt.ips[0]",
+		`limiters[1].headers: unknown header "limt"`,
+		`routes[0].limiters[1]: unknown limiter "nope"`,
+		"routes[1].pattern",
+		"routes[2].pattern: required",
+		"routes[2].limiters: required",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	if _, err := httprate.ParsePolicy([]byte(`{"limiters": [{"name": "api", "limit": 1, "window": "1 minute"}]}`), nil); err == nil {
+		t.Error("invalid duration: want error")
+	}
+	if _, err := httprate.ParsePolicy([]byte(`{"limitters": []}`), nil); err == nil {
+		t.Error("unknown field: want error")
+	}
+}
+
+func TestPolicyBuild(t *testing.T) {
+	p, err := httprate.ParsePolicy([]byte(`{"limiters": [
+		{"name": "a", "limit": 1, "window": "1m", "keys": ["ip", "tenant", "bogus", "header:"]}
+	]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Build(httprate.PolicyOptions{})
+	if err == nil {
+		t.Fatal("want build errors")
+	}
+	for _, want := range []string{`keys[0]: key "ip": PolicyOptions.ClientIP is required`, `keys[1]: unknown key "tenant"`, `keys[2]: unknown key "bogus"`, `keys[3]: unknown key "header:"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	opts := policyOptions()
+	opts.KeyFuncs = map[string]httprate.KeyFunc{"tenant": httprate.KeyByHeader("X-Tenant")}
+	if _, err := p.Build(opts); err == nil || strings.Contains(err.Error(), "tenant") {
+		t.Errorf("with custom key: err = %v, want only the unknown keys", err)
+	}
+}
+
+func TestPolicyPatternKey(t *testing.T) {
+	p, err := httprate.ParsePolicy([]byte(`{
+		"limiters": [{"name": "api", "limit": 1, "window": "1m", "keys": ["pattern"]}],
+		"routes": [
+			{"pattern": "/a/", "limiters": ["api"]},
+			{"pattern": "GET /b/{id}", "limiters": ["api"]}
+		]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := p.Build(policyOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every route has its own bucket, shared by the paths it matches.
+	h := set.Handler(okHandler())
+	wantCodes(t, []int{
+		policyRequest(h, "GET", "/a/1", "192.0.2.1").StatusCode,
+		policyRequest(h, "GET", "/a/2", "192.0.2.1").StatusCode,
+		policyRequest(h, "GET", "/b/1", "192.0.2.1").StatusCode,
+		policyRequest(h, "GET", "/b/2", "192.0.2.1").StatusCode,
+	}, []int{200, 429, 200, 429})
+}
+
+func TestWatchPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(policy string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := func(limit string) string {
+		return `{"limiters": [{"name": "api", "limit": ` + limit + `, "window": "1m", "keys": ["ip"]}],
+			"routes": [{"pattern": "/", "limiters": ["api"]}]}`
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write(policy("2"), start)
+
+	reloads := make(chan error, 10)
+	opts := policyOptions()
+	opts.OnReload = func(err error) { reloads <- err }
+	watcher, err := httprate.WatchPolicy(path, nil, opts, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	h := watcher.Handler(okHandler())
+	wantCodes(t, []int{policyRequest(h, "GET", "/", "192.0.2.1").StatusCode}, []int{200})
+
+	// Raising the limit keeps the count of the current window.
+	write(policy("3"), start.Add(time.Minute))
+	if err := <-reloads; err != nil {
+		t.Fatal(err)
+	}
+	if got := watcher.Current().Limiter("api").Limit(); got != 3 {
+		t.Fatalf("limit after reload = %v, want 3", got)
+	}
+	wantCodes(t, []int{
+		policyRequest(h, "GET", "/", "192.0.2.1").StatusCode,
+		policyRequest(h, "GET", "/", "192.0.2.1").StatusCode,
+		policyRequest(h, "GET", "/", "192.0.2.1").StatusCode,
+	}, []int{200, 200, 429})
+
+	// An invalid policy is reported and ignored.
+	write(policy("-1"), start.Add(2*time.Minute))
+	if err := <-reloads; err == nil {
+		t.Fatal("invalid policy: want reload error")
+	}
+	if got := watcher.Current().Limiter("api").Limit(); got != 3 {
+		t.Errorf("limit after invalid reload = %v, want 3", got)
+	}
+
+	// So is a policy failing to build, without changing the reused limiters.
+	write(`{"limiters": [{"name": "api", "limit": 99, "window": "1h", "keys": ["ip"]},
+			{"name": "other", "limit": 1, "window": "1m", "keys": ["bogus"]}],
+		"routes": [{"pattern": "/", "limiters": ["api", "other"]}]}`, start.Add(3*time.Minute))
+	if err := <-reloads; err == nil || !strings.Contains(err.Error(), `unknown key "bogus"`) {
+		t.Fatalf("reload error = %v, want the unknown key", err)
+	}
+	if l := watcher.Current().Limiter("api"); l.Limit() != 3 || l.WindowLength() != time.Minute {
+		t.Errorf("api after failed reload = %v per %v, want 3 per 1m", l.Limit(), l.WindowLength())
+	}
+	wantCodes(t, []int{policyRequest(h, "GET", "/", "192.0.2.1").StatusCode}, []int{429})
+}
+
+func TestWatchPolicySharedCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(window string, mtime time.Time) {
+		policy := `{"limiters": [{"name": "api", "limit": 2, "window": "` + window + `"}]}`
+		if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write("1m", start)
+
+	reloads := make(chan error, 10)
+	opts := policyOptions()
+	opts.Options = []httprate.Option{httprate.WithSharedLimitCounter(httprate.NewLocalLimitCounter(time.Minute))}
+	opts.OnReload = func(err error) { reloads <- err }
+	watcher, err := httprate.WatchPolicy(path, nil, opts, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// The window of a counter given with WithSharedLimitCounter can't change.
+	write("1h", start.Add(time.Minute))
+	if err := <-reloads; err == nil || !strings.Contains(err.Error(), "limiters[0]") {
+		t.Errorf("reload error = %v, want the limiter's SetWindow error", err)
+	}
+	if got := watcher.Current().Limiter("api").WindowLength(); got != time.Minute {
+		t.Errorf("window after failed reload = %v, want 1m", got)
+	}
+}
@@ -0,0 +1,97 @@
+package httprate
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// PriorityTier is a class of requests sharing a PriorityLimiter's capacity.
+type PriorityTier struct {
+	Name string
+
+	// Reserved is the share of the capacity, from 0 to 1, that lower tiers
+	// can't use.
+	Reserved float64
+}
+
+// NewPriorityLimiter creates a limiter sharing a global capacity of
+// requestLimit requests per windowLength between priority tiers, shedding
+// lower tiers first when overloaded. tiers are listed from the highest
+// priority down, and classify returns the name of a request's tier; requests
+// of unknown tiers get the lowest:
+//
+//	tiers := []httprate.PriorityTier{
+//		{Name: "paid", Reserved: 0.5},
+//		{Name: "free", Reserved: 0.3},
+//		{Name: "anonymous"},
+//	}
+//	r.Use(httprate.NewPriorityLimiter(10000, time.Minute, planOf, tiers).Handler)
+//
+// All tiers count in a single bucket. A request is allowed while the bucket's
+// rate is below the capacity left once the shares reserved by higher tiers are
+// set aside: here, anonymous requests are shed once the rate reaches 20% of
+// the capacity, free ones at 50%, and paid ones at 100%. The response headers
+// report the limit of the request's tier, and a tier left no share at all is
+// rejected, after exemptions and denylists apply.
+//
+// options configure the underlying RateLimiter (see NewRateLimiter), e.g. its
+// counter, key, handlers and hooks. A key other than the default gives every
+// key its own capacity. Per-key limits, from a LimitProvider or WithRequestLimit,
+// are scaled by the tiers' shares the same way.
+func NewPriorityLimiter(requestLimit int, windowLength time.Duration, classify func(r *http.Request) string, tiers []PriorityTier, options ...Option) *PriorityLimiter {
+	p := &PriorityLimiter{
+		limiter:  NewRateLimiter(requestLimit, windowLength, options...),
+		classify: classify,
+		tiers:    make(map[string]int, len(tiers)),
+		shares:   make([]float64, len(tiers)),
+	}
+	available := 1.0
+	for i, tier := range tiers {
+		p.tiers[tier.Name] = i
+		p.shares[i] = max(available, 0)
+		available -= tier.Reserved
+	}
+	return p
+}
+
+// PriorityLimiter is the limiter returned by NewPriorityLimiter.
+type PriorityLimiter struct {
+	limiter  *RateLimiter
+	classify func(r *http.Request) string
+	tiers    map[string]int // tier indexes by name
+	shares   []float64      // share of the capacity available to each tier
+}
+
+// Limiter returns the underlying RateLimiter, e.g. to register it with an
+// AdminHandler. Its request limit is the global capacity.
+func (p *PriorityLimiter) Limiter() *RateLimiter {
+	return p.limiter
+}
+
+// TierLimit returns the number of requests per window tier may use, counting
+// all tiers' requests, for keys with the limiter's request limit.
+func (p *PriorityLimiter) TierLimit(tier string) int {
+	return int(math.Round(float64(p.limiter.Limit()) * p.share(tier)))
+}
+
+// share returns the share of the capacity available to tier.
+func (p *PriorityLimiter) share(tier string) float64 {
+	i, ok := p.tiers[tier]
+	if !ok {
+		i = len(p.shares) - 1
+	}
+	if i < 0 {
+		return 1
+	}
+	return p.shares[i]
+}
+
+// Handler is a middleware limiting requests by tier.
+func (p *PriorityLimiter) Handler(next http.Handler) http.Handler {
+	limited := p.limiter.Handler(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		share := p.share(p.classify(r))
+		limited.ServeHTTP(w, r.WithContext(withLimitShare(r.Context(), p.limiter, share)))
+	})
+}
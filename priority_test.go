@@ -0,0 +1,144 @@
+package httprate_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestPriorityLimiter(t *testing.T) {
+	tiers := []httprate.PriorityTier{
+		{Name: "paid", Reserved: 0.5},
+		{Name: "free", Reserved: 0.3},
+		{Name: "anonymous"},
+	}
+	classify := func(r *http.Request) string { return r.Header.Get("X-Plan") }
+	p := httprate.NewPriorityLimiter(10, time.Minute, classify, tiers)
+	h := p.Handler(okHandler())
+
+	for tier, want := range map[string]int{"paid": 10, "free": 5, "anonymous": 2, "unknown": 2} {
+		if got := p.TierLimit(tier); got != want {
+			t.Errorf("TierLimit(%q) = %v, want %v", tier, got, want)
+		}
+	}
+
+	do := func(plan string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Plan", plan)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Anonymous traffic is shed first, then free, keeping paid customers served.
+	var anonymous, free, paid []int
+	for range 3 {
+		anonymous = append(anonymous, do("anonymous").Code)
+	}
+	for range 4 {
+		free = append(free, do("free").Code)
+	}
+	for range 6 {
+		paid = append(paid, do("paid").Code)
+	}
+	wantCodes(t, anonymous, []int{200, 200, 429})
+	wantCodes(t, free, []int{200, 200, 200, 429})
+	wantCodes(t, paid, []int{200, 200, 200, 200, 200, 429})
+
+	if got := do("free").Header().Get("X-RateLimit-Limit"); got != "5" {
+		t.Errorf("free X-RateLimit-Limit = %q, want 5", got)
+	}
+}
+
+func TestPriorityLimiterNoShare(t *testing.T) {
+	tiers := []httprate.PriorityTier{
+		{Name: "internal", Reserved: 1},
+		{Name: "public"},
+	}
+	classify := func(r *http.Request) string { return r.Header.Get("X-Plan") }
+	p := httprate.NewPriorityLimiter(10, time.Minute, classify, tiers,
+		httprate.WithExempt(func(r *http.Request) bool { return r.URL.Path == "/healthz" }),
+		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	h := p.Handler(okHandler())
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("StatusCode = %v, want 503 from the limit handler", recorder.Code)
+	}
+	if got := recorder.Header().Get("X-RateLimit-Limit"); got != "0" {
+		t.Errorf("X-RateLimit-Limit = %q, want 0", got)
+	}
+
+	// Exemptions still apply.
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("exempt: StatusCode = %v, want 200", recorder.Code)
+	}
+}
+
+func TestPriorityLimiterLimitProvider(t *testing.T) {
+	tiers := []httprate.PriorityTier{
+		{Name: "paid", Reserved: 0.5},
+		{Name: "free"},
+	}
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		if key == "enterprise" {
+			return httprate.KeyLimit{Limit: 100}, nil
+		}
+		return httprate.KeyLimit{}, nil
+	})
+	classify := func(r *http.Request) string { return r.Header.Get("X-Plan") }
+	p := httprate.NewPriorityLimiter(10, time.Minute, classify, tiers,
+		httprate.WithKeyFuncs(httprate.KeyByHeader("X-Tenant")),
+		httprate.WithLimitProvider(plans, 0))
+	h := p.Handler(okHandler())
+
+	// Per-key limits are scaled by the tier's share.
+	for _, tt := range []struct {
+		tenant, plan string
+		limit        string
+	}{
+		{tenant: "enterprise", plan: "paid", limit: "100"},
+		{tenant: "enterprise", plan: "free", limit: "50"},
+		{tenant: "startup", plan: "free", limit: "5"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Tenant", tt.tenant)
+		req.Header.Set("X-Plan", tt.plan)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		if got := recorder.Header().Get("X-RateLimit-Limit"); got != tt.limit {
+			t.Errorf("%s on %s: X-RateLimit-Limit = %q, want %v", tt.tenant, tt.plan, got, tt.limit)
+		}
+	}
+}
+
+func TestPriorityLimiterDownstream(t *testing.T) {
+	tiers := []httprate.PriorityTier{
+		{Name: "paid", Reserved: 0.8},
+		{Name: "free"},
+	}
+	classify := func(r *http.Request) string { return r.Header.Get("X-Plan") }
+	p := httprate.NewPriorityLimiter(100, time.Minute, classify, tiers)
+	login := httprate.LimitBy(5, time.Minute, httprate.Key("login"))
+	h := p.Handler(login(okHandler()))
+
+	// The free tier's share only scales the priority limiter's limit.
+	var codes []int
+	for range 5 {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.Header.Set("X-Plan", "free")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		codes = append(codes, recorder.Code)
+	}
+	wantCodes(t, codes, []int{200, 200, 200, 200, 200})
+}
@@ -0,0 +1,315 @@
+package httprate
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaStore persists the usage counted by a Quota, per key and period. It
+// must be safe for concurrent use, and shared by all instances enforcing the
+// same quota, e.g. a database or Redis.
+type QuotaStore interface {
+	// Increment adds amount to the usage of key in the period starting at
+	// period. If max is positive and the usage would exceed it, the usage is
+	// left unchanged and ok is false. It returns the resulting usage.
+	// Checking and adding must be atomic, so concurrent requests can't
+	// overshoot max.
+	Increment(ctx context.Context, key string, period time.Time, amount, max int) (usage int, ok bool, err error)
+
+	// Usage returns the usage of key in the period starting at period.
+	Usage(ctx context.Context, key string, period time.Time) (int, error)
+}
+
+// QuotaOptions configures a Quota (see NewQuota).
+type QuotaOptions struct {
+	// Name identifies the quota, e.g. in the admin handler (see
+	// AdminHandler.RegisterQuota).
+	Name string
+
+	// Limit is the number of requests allowed per period. It must be
+	// positive.
+	Limit int
+
+	// Limits resolves the limit of each key, e.g. per subscription plan; only
+	// KeyLimit.Limit is used. Zero limits fall back to Limit, and negative
+	// limits block the key outright, even if Soft, e.g. for suspended
+	// accounts.
+	Limits LimitProvider
+
+	// Period and Location define the calendar periods usage is counted in,
+	// e.g. monthly in the customer's timezone.
+	// Default: Monthly, in UTC
+	Period   CalendarPeriod
+	Location *time.Location
+
+	// KeyFunc keys requests, e.g. by API key or tenant.
+	// Default: a single quota for all requests
+	KeyFunc KeyFunc
+
+	// Store persists usage.
+	// Default: an in-memory store (see NewMemoryQuotaStore)
+	Store QuotaStore
+
+	// Soft lets requests over quota through, counted as overage and flagged
+	// with the Overage header, e.g. to bill overage rather than cut customers
+	// off. By default, requests over quota are rejected.
+	Soft bool
+
+	// Headers are the response headers. An empty name omits the header.
+	// Default: X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset,
+	// X-Quota-Overage and Retry-After
+	Headers *QuotaHeaders
+
+	// OnExceeded responds to requests over quota.
+	// Default: 429 Too Many Requests
+	OnExceeded http.HandlerFunc
+
+	// OnError responds to requests whose key or usage could not be resolved.
+	// Default: the same as the rate limiter's (see WithErrorHandler)
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// QuotaHeaders are the response headers of a Quota.
+type QuotaHeaders struct {
+	Limit      string
+	Remaining  string
+	Reset      string
+	Overage    string // requests over quota in the period, see QuotaOptions.Soft
+	RetryAfter string // seconds until the period resets, on rejected requests
+}
+
+// QuotaUsage is the usage of a key in a period.
+type QuotaUsage struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Overage   int       `json:"overage"`
+	Start     time.Time `json:"start"`
+	Reset     time.Time `json:"reset"`
+}
+
+// NewQuota creates a long-term quota, e.g. 1M requests per month, counted in
+// calendar periods and persisted in a QuotaStore. Layer it with short-term
+// rate limits:
+//
+//	quota := httprate.NewQuota(httprate.QuotaOptions{
+//		Name:    "monthly",
+//		Limit:   1_000_000,
+//		Period:  httprate.Monthly,
+//		KeyFunc: httprate.KeyByHeader("X-API-Key", httprate.KeyRequired()),
+//		Store:   store,
+//	})
+//	r.Use(httprate.LimitBy(100, time.Second, apiKey)) // burst protection
+//	r.Use(quota.Handler)
+//
+// Requests count with their increment (see WithIncrement). NewQuota panics if
+// opts.Limit isn't positive.
+func NewQuota(opts QuotaOptions) *Quota {
+	if opts.Limit <= 0 {
+		panic("httprate: quota limit must be positive")
+	}
+	if opts.Period == 0 {
+		opts.Period = Monthly
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = Key("*")
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryQuotaStore()
+	}
+	if opts.Headers == nil {
+		opts.Headers = &QuotaHeaders{
+			Limit:      "X-Quota-Limit",
+			Remaining:  "X-Quota-Remaining",
+			Reset:      "X-Quota-Reset",
+			Overage:    "X-Quota-Overage",
+			RetryAfter: "Retry-After",
+		}
+	}
+	if opts.OnExceeded == nil {
+		opts.OnExceeded = onRateLimited
+	}
+	if opts.OnError == nil {
+		opts.OnError = onError
+	}
+	return &Quota{
+		opts:     opts,
+		calendar: &calendarWindow{period: opts.Period, loc: opts.Location},
+	}
+}
+
+// Quota is the middleware returned by NewQuota.
+type Quota struct {
+	opts     QuotaOptions
+	calendar *calendarWindow
+}
+
+// Name returns the quota's name.
+func (q *Quota) Name() string {
+	return q.opts.Name
+}
+
+// Handler is a middleware counting requests against the quota.
+func (q *Quota) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := q.opts.KeyFunc(r)
+		if err != nil {
+			q.opts.OnError(w, r, err)
+			return
+		}
+		if q.RespondOnQuota(w, r, key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RespondOnQuota counts the request against key's quota and sets the response
+// headers. If the request is over a hard quota, or the usage can't be
+// counted, it responds and returns true, signaling the caller to halt the
+// request.
+func (q *Quota) RespondOnQuota(w http.ResponseWriter, r *http.Request, key string) bool {
+	ctx := r.Context()
+	now := time.Now()
+	start := q.calendar.start(now)
+	reset := q.calendar.end(start)
+
+	limit, err := q.limit(ctx, key)
+	if err != nil {
+		q.opts.OnError(w, r, err)
+		return true
+	}
+	setHeader(w, q.opts.Headers.Limit, strconv.Itoa(limit))
+	setHeader(w, q.opts.Headers.Reset, strconv.FormatInt(reset.Unix(), 10))
+
+	exceeded := func() bool {
+		setHeader(w, q.opts.Headers.RetryAfter, strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+		q.opts.OnExceeded(w, r)
+		return true
+	}
+	if limit == 0 {
+		// Blocked by the LimitProvider.
+		setHeader(w, q.opts.Headers.Remaining, "0")
+		return exceeded()
+	}
+
+	hardLimit := limit
+	if q.opts.Soft {
+		hardLimit = 0
+	}
+	used, ok, err := q.opts.Store.Increment(ctx, key, start, getIncrement(ctx), hardLimit)
+	if err != nil {
+		q.opts.OnError(w, r, err)
+		return true
+	}
+
+	setHeader(w, q.opts.Headers.Remaining, strconv.Itoa(max(limit-used, 0)))
+	if !ok {
+		return exceeded()
+	}
+	if used > limit {
+		setHeader(w, q.opts.Headers.Overage, strconv.Itoa(used-limit))
+	}
+	return false
+}
+
+// Usage returns key's usage in the period containing at, e.g. for billing
+// dashboards.
+func (q *Quota) Usage(ctx context.Context, key string, at time.Time) (QuotaUsage, error) {
+	start := q.calendar.start(at)
+
+	limit, err := q.limit(ctx, key)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	used, err := q.opts.Store.Usage(ctx, key, start)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	return QuotaUsage{
+		Key:       key,
+		Limit:     limit,
+		Used:      used,
+		Remaining: max(limit-used, 0),
+		Overage:   max(used-limit, 0),
+		Start:     start,
+		Reset:     q.calendar.end(start),
+	}, nil
+}
+
+// limit returns key's limit, or zero if the key is blocked.
+func (q *Quota) limit(ctx context.Context, key string) (int, error) {
+	if q.opts.Limits != nil {
+		kl, err := q.opts.Limits.KeyLimit(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case kl.Limit > 0:
+			return kl.Limit, nil
+		case kl.Limit < 0:
+			return 0, nil
+		}
+	}
+	return q.opts.Limit, nil
+}
+
+// NewMemoryQuotaStore creates an in-memory QuotaStore, for tests and single
+// instances that can afford to lose usage on restart. It keeps every period
+// until pruned (see Prune).
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[quotaPeriodKey]int)}
+}
+
+// MemoryQuotaStore is the QuotaStore returned by NewMemoryQuotaStore.
+type MemoryQuotaStore struct {
+	usage map[quotaPeriodKey]int
+	mu    sync.Mutex
+}
+
+type quotaPeriodKey struct {
+	key    string
+	period int64 // start of the period in Unix nanoseconds
+}
+
+var _ QuotaStore = (*MemoryQuotaStore)(nil)
+
+func (s *MemoryQuotaStore) Increment(ctx context.Context, key string, period time.Time, amount, max int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := quotaPeriodKey{key: key, period: period.UnixNano()}
+	used := s.usage[k]
+	if max > 0 && used+amount > max {
+		return used, false, nil
+	}
+	s.usage[k] = used + amount
+	return used + amount, true, nil
+}
+
+func (s *MemoryQuotaStore) Usage(ctx context.Context, key string, period time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage[quotaPeriodKey{key: key, period: period.UnixNano()}], nil
+}
+
+// Prune forgets the usage of the periods starting before t.
+func (s *MemoryQuotaStore) Prune(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.usage {
+		if k.period < t.UnixNano() {
+			delete(s.usage, k)
+		}
+	}
+}
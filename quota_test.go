@@ -0,0 +1,232 @@
+package httprate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestQuotaHard(t *testing.T) {
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		if key == "enterprise" {
+			return httprate.KeyLimit{Limit: 5}, nil
+		}
+		return httprate.KeyLimit{}, nil
+	})
+	quota := httprate.NewQuota(httprate.QuotaOptions{
+		Limit:   2,
+		Limits:  plans,
+		Period:  httprate.Daily,
+		KeyFunc: httprate.KeyByHeader("X-API-Key"),
+	})
+	h := quota.Handler(okHandler())
+
+	do := func(apiKey string) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	var free, enterprise []int
+	for range 6 {
+		free = append(free, do("free").StatusCode)
+		enterprise = append(enterprise, do("enterprise").StatusCode)
+	}
+	wantCodes(t, free, []int{200, 200, 429, 429, 429, 429})
+	wantCodes(t, enterprise, []int{200, 200, 200, 200, 200, 429})
+
+	resp := do("free")
+	if got := resp.Header.Get("X-Quota-Limit"); got != "2" {
+		t.Errorf("X-Quota-Limit = %q, want 2", got)
+	}
+	if got := resp.Header.Get("X-Quota-Remaining"); got != "0" {
+		t.Errorf("X-Quota-Remaining = %q, want 0", got)
+	}
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-Quota-Reset"), 10, 64)
+	if got := time.Unix(reset, 0).UTC(); got.Hour() != 0 || got.Minute() != 0 || !got.After(time.Now()) {
+		t.Errorf("X-Quota-Reset = %v, want next midnight UTC", got)
+	}
+
+	// Rejections retry once the period resets.
+	if retry, _ := strconv.Atoi(resp.Header.Get("Retry-After")); time.Duration(retry)*time.Second < time.Until(time.Unix(reset, 0)) {
+		t.Errorf("Retry-After = %q, want the seconds until %v", resp.Header.Get("Retry-After"), time.Unix(reset, 0))
+	}
+
+	// Rejected requests aren't counted.
+	usage, err := quota.Usage(context.Background(), "free", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != 2 || usage.Remaining != 0 || usage.Overage != 0 {
+		t.Errorf("usage = %+v, want 2 used", usage)
+	}
+}
+
+func TestQuotaBlocked(t *testing.T) {
+	plans := httprate.LimitProviderFunc(func(ctx context.Context, key string) (httprate.KeyLimit, error) {
+		if key == "suspended" {
+			return httprate.KeyLimit{Limit: -1}, nil
+		}
+		return httprate.KeyLimit{}, nil
+	})
+	quota := httprate.NewQuota(httprate.QuotaOptions{
+		Limit:   2,
+		Limits:  plans,
+		Soft:    true,
+		KeyFunc: httprate.KeyByHeader("X-API-Key"),
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "suspended")
+	recorder := httptest.NewRecorder()
+	quota.Handler(okHandler()).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("StatusCode = %v, want 429", recorder.Code)
+	}
+	if got := recorder.Header().Get("X-Quota-Limit"); got != "0" {
+		t.Errorf("X-Quota-Limit = %q, want 0", got)
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After unset")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("NewQuota without Limit: want panic")
+		}
+	}()
+	httprate.NewQuota(httprate.QuotaOptions{})
+}
+
+func TestQuotaSoft(t *testing.T) {
+	quota := httprate.NewQuota(httprate.QuotaOptions{
+		Limit: 2,
+		Soft:  true,
+	})
+	h := quota.Handler(okHandler())
+
+	var codes []int
+	var overage []string
+	for range 4 {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, recorder.Code)
+		overage = append(overage, recorder.Header().Get("X-Quota-Overage"))
+	}
+	wantCodes(t, codes, []int{200, 200, 200, 200})
+	if overage[1] != "" || overage[2] != "1" || overage[3] != "2" {
+		t.Errorf("X-Quota-Overage = %q, want [\"\" \"\" 1 2]", overage)
+	}
+
+	usage, err := quota.Usage(context.Background(), "*", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != 4 || usage.Overage != 2 || usage.Remaining != 0 {
+		t.Errorf("usage = %+v, want 4 used, 2 overage", usage)
+	}
+}
+
+func TestQuotaPeriods(t *testing.T) {
+	store := httprate.NewMemoryQuotaStore()
+	quota := httprate.NewQuota(httprate.QuotaOptions{Limit: 10, Period: httprate.Monthly, Store: store})
+
+	march := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	store.Increment(context.Background(), "acme", march, 7, 0)
+	store.Increment(context.Background(), "acme", april, 3, 0)
+
+	usage, err := quota.Usage(context.Background(), "acme", time.Date(2026, time.March, 20, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != 7 || usage.Remaining != 3 || usage.Start != march || usage.Reset != april {
+		t.Errorf("March usage = %+v", usage)
+	}
+
+	store.Prune(april)
+	if usage, _ := quota.Usage(context.Background(), "acme", march); usage.Used != 0 {
+		t.Errorf("March usage after Prune = %v, want 0", usage.Used)
+	}
+	if usage, _ := quota.Usage(context.Background(), "acme", april); usage.Used != 3 {
+		t.Errorf("April usage after Prune = %v, want 3", usage.Used)
+	}
+}
+
+type failingQuotaStore struct{}
+
+func (failingQuotaStore) Increment(ctx context.Context, key string, period time.Time, amount, max int) (int, bool, error) {
+	return 0, false, errors.New("store unavailable")
+}
+
+func (failingQuotaStore) Usage(ctx context.Context, key string, period time.Time) (int, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func TestQuotaStoreError(t *testing.T) {
+	quota := httprate.NewQuota(httprate.QuotaOptions{
+		Limit: 10,
+		Store: failingQuotaStore{},
+		OnError: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	quota.Handler(okHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("StatusCode = %v, want 503", recorder.Code)
+	}
+}
+
+func TestAdminHandlerQuota(t *testing.T) {
+	quota := httprate.NewQuota(httprate.QuotaOptions{Name: "monthly", Limit: 100})
+	admin := httprate.NewAdminHandler(func(r *http.Request) bool { return true })
+	if err := admin.RegisterQuota(quota); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.RegisterQuota(httprate.NewQuota(httprate.QuotaOptions{Limit: 1})); err == nil {
+		t.Error("RegisterQuota(unnamed quota): want error")
+	}
+
+	for range 3 {
+		quota.Handler(okHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	do := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		admin.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	var usage httprate.QuotaUsage
+	if err := json.NewDecoder(do("/quotas/monthly/keys/*").Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Key != "*" || usage.Limit != 100 || usage.Used != 3 || usage.Remaining != 97 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	if err := json.NewDecoder(do("/quotas/monthly/keys/*?at=2020-01-15T00:00:00Z").Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != 0 || !usage.Start.Equal(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("past usage = %+v", usage)
+	}
+
+	if code := do("/quotas/monthly/keys/*?at=yesterday").Code; code != http.StatusBadRequest {
+		t.Errorf("invalid at: StatusCode = %v, want 400", code)
+	}
+	if code := do("/quotas/daily/keys/*").Code; code != http.StatusNotFound {
+		t.Errorf("unknown quota: StatusCode = %v, want 404", code)
+	}
+}
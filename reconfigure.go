@@ -0,0 +1,157 @@
+package httprate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// limiterConfig is the part of a RateLimiter's configuration that can change
+// at runtime (see SetLimit, SetWindow and SetHeaders). It is immutable once
+// the limiter is created: changes store a modified copy, so every decision
+// sees a consistent configuration.
+type limiterConfig struct {
+	requestLimit int
+	windowLength time.Duration
+	windowOffset time.Duration
+	headers      ResponseHeaders
+	calendar     *calendarWindow // see WithCalendarWindow
+}
+
+func (l *RateLimiter) config() *limiterConfig {
+	return l.cfg.Load()
+}
+
+// windowStart returns the start of the window of the given length containing
+// t. Windows of the limiter's length are calendar windows if it has one, or
+// else aligned to windowOffset; others are aligned to start, or to the wall
+// clock if start is zero. The result is always in (t-window, t].
+func (cfg *limiterConfig) windowStart(t time.Time, window time.Duration, start time.Time) time.Time {
+	if window == cfg.windowLength && cfg.calendar != nil {
+		return cfg.calendar.start(t)
+	}
+	offset := cfg.windowOffset
+	if window != cfg.windowLength {
+		offset = 0
+		if !start.IsZero() {
+			offset = start.Sub(start.Truncate(window))
+		}
+	}
+	return t.Add(-offset).Truncate(window).Add(offset)
+}
+
+// windowEnd returns the end of the window of the given length starting at
+// windowStart.
+func (cfg *limiterConfig) windowEnd(windowStart time.Time, window time.Duration) time.Time {
+	if window == cfg.windowLength && cfg.calendar != nil {
+		return cfg.calendar.end(windowStart)
+	}
+	return windowStart.Add(window)
+}
+
+// SetLimit changes the limiter's request limit per window, e.g. to tighten
+// limits during an incident without a redeploy. It is safe to call while
+// serving requests: requests being decided finish with the previous limit, and
+// the following ones use the new one. Requests already counted in the current
+// window still count. The limiter's LimitCounter is notified with
+// LimitCounter.Config, unless given with WithSharedLimitCounter.
+//
+// Limits resolved per key (see WithLimitProvider) or per request (see
+// WithRequestLimit) still take precedence.
+func (l *RateLimiter) SetLimit(requestLimit int) error {
+	if requestLimit <= 0 {
+		return fmt.Errorf("httprate: invalid request limit %d", requestLimit)
+	}
+
+	l.cfgMu.Lock()
+	defer l.cfgMu.Unlock()
+
+	cfg := *l.config()
+	cfg.requestLimit = requestLimit
+	l.reconfigure(&cfg)
+	return nil
+}
+
+// SetWindow changes the limiter's window length. It is safe to call while
+// serving requests, like SetLimit.
+//
+// Counts can't be carried over to windows of another length: requests
+// counted in the previous windows are forgotten, and every key starts afresh
+// with the new window. To throttle clients harder during an incident, prefer
+// SetLimit, which keeps the counts.
+//
+// The window of a LimitCounter given with WithSharedLimitCounter can't be
+// changed: SetWindow returns an error.
+func (l *RateLimiter) SetWindow(windowLength time.Duration) error {
+	l.cfgMu.Lock()
+	defer l.cfgMu.Unlock()
+
+	if err := l.checkWindow(windowLength); err != nil {
+		return err
+	}
+	cfg := *l.config()
+	if cfg.windowLength == windowLength {
+		return nil
+	}
+	cfg.windowLength = windowLength
+	if l.localCounter {
+		cfg.windowOffset = l.start.Sub(l.start.Truncate(windowLength))
+	}
+	l.reconfigure(&cfg)
+	return nil
+}
+
+// checkWindow returns the error SetWindow would return for windowLength,
+// without changing anything.
+func (l *RateLimiter) checkWindow(windowLength time.Duration) error {
+	if windowLength <= 0 {
+		return fmt.Errorf("httprate: invalid window length %v", windowLength)
+	}
+	cfg := l.config()
+	if cfg.windowLength == windowLength {
+		return nil
+	}
+	if cfg.calendar != nil {
+		return errors.New("httprate: cannot change the window of a limiter with a calendar window")
+	}
+	if l.sharedCounter {
+		return fmt.Errorf("httprate: cannot change the window of %T given with WithSharedLimitCounter", l.limitCounter)
+	}
+	return nil
+}
+
+// SetHeaders changes the limiter's response headers (see
+// WithResponseHeaders). It is safe to call while serving requests.
+func (l *RateLimiter) SetHeaders(headers ResponseHeaders) {
+	l.cfgMu.Lock()
+	defer l.cfgMu.Unlock()
+
+	cfg := *l.config()
+	cfg.headers = headers
+	l.cfg.Store(&cfg)
+}
+
+// reconfigure notifies the limiter's LimitCounters, unless shared, of a new
+// limit or window length, and stores cfg. The caller must hold cfgMu.
+func (l *RateLimiter) reconfigure(cfg *limiterConfig) {
+	// Wait for the decisions being counted, so they don't mix windows.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.sharedCounter {
+		l.limitCounter.Config(cfg.requestLimit, cfg.windowLength)
+	}
+
+	l.countersMu.Lock()
+	for window, counter := range l.windowCounters {
+		if window == cfg.windowLength {
+			// Counted by the main counter from now on.
+			delete(l.windowCounters, window)
+			continue
+		}
+		counter.Config(cfg.requestLimit, window)
+	}
+	l.countersMu.Unlock()
+
+	l.cfg.Store(cfg)
+}
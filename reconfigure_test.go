@@ -0,0 +1,182 @@
+package httprate_test
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// configRecorder is a LimitCounter recording the configurations it is given.
+type configRecorder struct {
+	recordingCounter
+	limits  []int
+	windows []time.Duration
+}
+
+func (c *configRecorder) Config(requestLimit int, windowLength time.Duration) {
+	c.limits = append(c.limits, requestLimit)
+	c.windows = append(c.windows, windowLength)
+}
+
+func TestSetLimit(t *testing.T) {
+	rl := httprate.NewRateLimiter(3, time.Minute)
+	onLimit := func() (bool, string) {
+		recorder := httptest.NewRecorder()
+		limited := rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "key")
+		return limited, recorder.Header().Get("X-RateLimit-Limit")
+	}
+
+	onLimit()
+	onLimit()
+	if err := rl.SetLimit(2); err != nil {
+		t.Fatal(err)
+	}
+	// The 2 requests counted before the change still count.
+	if limited, limit := onLimit(); !limited || limit != "2" {
+		t.Errorf("after SetLimit(2): limited=%v limit=%q, want true \"2\"", limited, limit)
+	}
+	if rl.Limit() != 2 {
+		t.Errorf("Limit() = %v, want 2", rl.Limit())
+	}
+
+	if err := rl.SetLimit(0); err == nil {
+		t.Error("SetLimit(0): want error")
+	}
+}
+
+func TestSetWindow(t *testing.T) {
+	// Counters created by the limiter are reconfigured.
+	counter := &configRecorder{}
+	rl := httprate.NewRateLimiter(1, time.Minute, httprate.WithLimitCounterFactory(func(time.Duration) httprate.LimitCounter {
+		return counter
+	}))
+	if err := rl.SetLimit(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.SetWindow(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	wantLimits, wantWindows := []int{1, 5, 5}, []time.Duration{time.Minute, time.Minute, time.Hour}
+	for i := range wantLimits {
+		if len(counter.limits) != len(wantLimits) || counter.limits[i] != wantLimits[i] || counter.windows[i] != wantWindows[i] {
+			t.Fatalf("Config calls = %v %v, want %v %v", counter.limits, counter.windows, wantLimits, wantWindows)
+		}
+	}
+	if err := rl.SetWindow(-time.Second); err == nil {
+		t.Error("SetWindow(-1s): want error")
+	}
+
+	// So are counters given with WithLimitCounter, e.g. Redis.
+	counter = &configRecorder{}
+	rl = httprate.NewRateLimiter(1, time.Minute, httprate.WithLimitCounter(counter))
+	if err := rl.SetLimit(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.SetWindow(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for i := range wantLimits {
+		if len(counter.limits) != len(wantLimits) || counter.limits[i] != wantLimits[i] || counter.windows[i] != wantWindows[i] {
+			t.Fatalf("WithLimitCounter: Config calls = %v %v, want %v %v", counter.limits, counter.windows, wantLimits, wantWindows)
+		}
+	}
+
+	// The local counter starts afresh with the new window.
+	rl = httprate.NewRateLimiter(1, time.Minute)
+	req := httptest.NewRequest("GET", "/", nil)
+	rl.OnLimit(httptest.NewRecorder(), req, "key")
+	if err := rl.SetWindow(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	if rl.OnLimit(recorder, req, "key") {
+		t.Error("after SetWindow: want the key to start afresh")
+	}
+	if got := recorder.Header().Get("Retry-After"); got != "" {
+		t.Errorf("Retry-After = %q on an allowed request", got)
+	}
+	if !rl.OnLimit(recorder, req, "key") {
+		t.Error("want limited at 1 request per hour")
+	}
+	if got := recorder.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", got)
+	}
+}
+
+func TestReconfigureSharedCounter(t *testing.T) {
+	// Counters given with WithSharedLimitCounter aren't reconfigured.
+	counter := httprate.NewLocalLimitCounter(time.Minute)
+	login := httprate.NewRateLimiter(2, time.Minute, httprate.WithName("login"), httprate.WithSharedLimitCounter(counter))
+	search := httprate.NewRateLimiter(2, time.Minute, httprate.WithName("search"), httprate.WithSharedLimitCounter(counter))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	search.OnLimit(httptest.NewRecorder(), req, "key")
+	search.OnLimit(httptest.NewRecorder(), req, "key")
+
+	if err := login.SetWindow(time.Hour); err == nil {
+		t.Error("SetWindow with a shared counter: want error")
+	}
+	if err := login.SetLimit(10); err != nil {
+		t.Fatal(err)
+	}
+	if login.WindowLength() != time.Minute {
+		t.Errorf("WindowLength() = %v, want unchanged", login.WindowLength())
+	}
+
+	// The other limiter's counts are intact.
+	if !search.OnLimit(httptest.NewRecorder(), req, "key") {
+		t.Error("search: want limited, its counts kept")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	rl := httprate.NewRateLimiter(10, time.Minute)
+	rl.SetHeaders(httprate.ResponseHeaders{Limit: "RateLimit-Limit"})
+
+	recorder := httptest.NewRecorder()
+	rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "key")
+	if got := recorder.Header().Get("RateLimit-Limit"); got != "10" {
+		t.Errorf("RateLimit-Limit = %q, want 10", got)
+	}
+	if got := recorder.Header().Get("X-RateLimit-Remaining"); got != "" {
+		t.Errorf("X-RateLimit-Remaining = %q, want omitted", got)
+	}
+}
+
+func TestReconfigureConcurrently(t *testing.T) {
+	rl := httprate.NewRateLimiter(100, time.Second)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				rl.OnLimit(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "key")
+				if i == 0 && j%10 == 0 {
+					rl.SetLimit(50 + j)
+					rl.SetWindow(time.Duration(1+j%3) * time.Second)
+					rl.SetHeaders(httprate.ResponseHeaders{Limit: "X-Limit"})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The last changes, at j == 90, win.
+	if rl.Limit() != 140 || rl.WindowLength() != time.Second {
+		t.Errorf("Limit() = %v, WindowLength() = %v, want 140, 1s", rl.Limit(), rl.WindowLength())
+	}
+	recorder := httptest.NewRecorder()
+	rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "key")
+	if got := recorder.Header().Get("X-Limit"); got != "140" {
+		t.Errorf("X-Limit = %q, want 140", got)
+	}
+	// Requests are only counted when allowed, so never past the highest limit.
+	if _, rate, err := rl.Status("key"); err != nil || rate <= 0 || rate > 140 {
+		t.Errorf("Status rate = %v, %v, want within (0, 140]", rate, err)
+	}
+}
@@ -0,0 +1,214 @@
+package httprate
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// CalendarPeriod is the period of a calendar window (see WithCalendarWindow).
+type CalendarPeriod int
+
+const (
+	// Daily windows start at midnight.
+	Daily CalendarPeriod = iota + 1
+	// Weekly windows start on Monday at midnight.
+	Weekly
+	// Monthly windows start on the first day of the month at midnight.
+	Monthly
+)
+
+// WithCalendarWindow counts requests in fixed calendar windows, e.g. days
+// starting at midnight in the customer's timezone, instead of a sliding window
+// of the limiter's length: a daily quota resets at midnight in loc, and the
+// Reset and Retry-After headers point there.
+//
+//	paris, _ := time.LoadLocation("Europe/Paris")
+//	r.Use(httprate.LimitBy(10000, 24*time.Hour, tenantKey,
+//		httprate.WithCalendarWindow(httprate.Daily, paris)))
+//
+// The window length given to the limiter is replaced by the period's nominal
+// length (24h, 7 days or 31 days), which LimitCounters are configured with,
+// and the limiter's window can't be changed with SetWindow. Keys given another
+// window by a LimitProvider keep sliding windows.
+func WithCalendarWindow(period CalendarPeriod, loc *time.Location) Option {
+	return func(rl *RateLimiter) {
+		if loc == nil {
+			loc = time.UTC
+		}
+		rl.config().calendar = &calendarWindow{period: period, loc: loc}
+	}
+}
+
+type calendarWindow struct {
+	period CalendarPeriod
+	loc    *time.Location
+}
+
+// nominalLength is the longest length of a window.
+func (c *calendarWindow) nominalLength() time.Duration {
+	switch c.period {
+	case Weekly:
+		return 7 * 24 * time.Hour
+	case Monthly:
+		return 31 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// start returns the start of the window containing t, in UTC so windows
+// compare equal with ==.
+func (c *calendarWindow) start(t time.Time) time.Time {
+	y, m, d := t.In(c.loc).Date()
+	switch c.period {
+	case Weekly:
+		weekday := time.Date(y, m, d, 0, 0, 0, 0, c.loc).Weekday()
+		d -= (int(weekday) + 6) % 7 // days since Monday
+	case Monthly:
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, c.loc).UTC()
+}
+
+// end returns the end of the window starting at start.
+func (c *calendarWindow) end(start time.Time) time.Time {
+	y, m, d := start.In(c.loc).Date()
+	switch c.period {
+	case Weekly:
+		d += 7
+	case Monthly:
+		m++
+	default:
+		d++
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, c.loc).UTC()
+}
+
+// NewSchedule creates a LimitProvider changing the limit with the time of day
+// and the day of the week in loc (see WithLimitProvider), e.g. lower limits
+// during peak business hours:
+//
+//	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+//	peak := httprate.NewSchedule(ny,
+//		httprate.ScheduleRule{Days: weekdays, From: 9 * time.Hour, To: 17 * time.Hour, Limit: 100},
+//		httprate.ScheduleRule{From: 22 * time.Hour, To: 6 * time.Hour, Limit: 1000}, // every night
+//	)
+//	r.Use(httprate.LimitBy(300, time.Minute, tenantKey, httprate.WithLimitProvider(peak, 0)))
+//
+// The first matching rule applies; outside all rules, the limiter's limit
+// does. The Reset header never points past the next schedule boundary, when
+// the limit may change. A Schedule depends on the time, so don't cache it
+// (use a zero cacheTTL).
+func NewSchedule(loc *time.Location, rules ...ScheduleRule) *Schedule {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Schedule{loc: loc, rules: rules}
+}
+
+// Schedule is the LimitProvider returned by NewSchedule.
+type Schedule struct {
+	loc   *time.Location
+	rules []ScheduleRule
+}
+
+// ScheduleRule is a limit applying on some days between two times of day.
+type ScheduleRule struct {
+	// Days the rule applies on, starting at From. Empty means every day.
+	Days []time.Weekday
+
+	// From and To are the times of day the rule applies between, as durations
+	// since midnight, e.g. 9*time.Hour for 9am. If To is before From, the rule
+	// spans midnight and ends at To on the next day.
+	From, To time.Duration
+
+	// Limit and Window are the limit applying (see KeyLimit).
+	Limit  int
+	Window time.Duration
+}
+
+var _ LimitProvider = (*Schedule)(nil)
+
+// KeyLimit implements LimitProvider, returning the same limit for all keys.
+func (s *Schedule) KeyLimit(ctx context.Context, key string) (KeyLimit, error) {
+	return s.At(time.Now()), nil
+}
+
+// At returns the limit applying at t, with Until set to the next schedule
+// boundary. Times of day are wall-clock times in the schedule's location, so
+// rules keep applying at the same hours on days with a DST transition.
+func (s *Schedule) At(t time.Time) KeyLimit {
+	t = t.In(s.loc)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	var kl KeyLimit
+	for _, rule := range s.rules {
+		if rule.matches(t.Weekday(), clock) {
+			kl = KeyLimit{Limit: rule.Limit, Window: rule.Window}
+			break
+		}
+	}
+	kl.Until = s.nextBoundary(t)
+	return kl
+}
+
+func (rule ScheduleRule) matches(day time.Weekday, clock time.Duration) bool {
+	if rule.From <= rule.To {
+		return rule.on(day) && rule.From <= clock && clock < rule.To
+	}
+	// Spans midnight: from From today, or until To if it started yesterday.
+	return rule.on(day) && clock >= rule.From || rule.on(yesterday(day)) && clock < rule.To
+}
+
+func (rule ScheduleRule) on(day time.Weekday) bool {
+	return len(rule.Days) == 0 || slices.Contains(rule.Days, day)
+}
+
+// nextBoundary returns the first start or end of a rule after t, on the days
+// the rule applies, or the zero time if there are none.
+func (s *Schedule) nextBoundary(t time.Time) time.Time {
+	var next time.Time
+	y, m, d := t.Date()
+	for day := 0; day <= 7; day++ {
+		weekday := time.Date(y, m, d+day, 0, 0, 0, 0, s.loc).Weekday()
+		for _, rule := range s.rules {
+			// A rule spanning midnight ends on the day after it started.
+			ends := rule.on(weekday)
+			if rule.To < rule.From {
+				ends = rule.on(yesterday(weekday))
+			}
+
+			var boundaries []time.Duration
+			if rule.on(weekday) {
+				boundaries = append(boundaries, rule.From)
+			}
+			if ends {
+				boundaries = append(boundaries, rule.To)
+			}
+			for _, clock := range boundaries {
+				if b := atClock(y, m, d+day, clock, s.loc); b.After(t) && (next.IsZero() || b.Before(next)) {
+					next = b
+				}
+			}
+		}
+		if !next.IsZero() {
+			return next.UTC()
+		}
+	}
+	return next
+}
+
+// atClock returns the wall-clock time clock since midnight on the given day in
+// loc.
+func atClock(y int, m time.Month, d int, clock time.Duration, loc *time.Location) time.Time {
+	h, clock := clock/time.Hour, clock%time.Hour
+	minute, clock := clock/time.Minute, clock%time.Minute
+	sec, nsec := clock/time.Second, clock%time.Second
+	return time.Date(y, m, d, int(h), int(minute), int(sec), int(nsec), loc)
+}
+
+func yesterday(day time.Weekday) time.Weekday {
+	return (day + 6) % 7
+}
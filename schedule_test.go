@@ -0,0 +1,120 @@
+package httprate_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestScheduleAt(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	s := httprate.NewSchedule(ny,
+		httprate.ScheduleRule{Days: weekdays, From: 9 * time.Hour, To: 17 * time.Hour, Limit: 100},
+		httprate.ScheduleRule{Days: []time.Weekday{time.Friday}, From: 22 * time.Hour, To: 6 * time.Hour, Limit: 1000, Window: time.Hour},
+	)
+
+	at := func(day, hour, min int) time.Time { return time.Date(2026, time.May, day, hour, min, 0, 0, ny) }
+	tests := []struct {
+		name   string
+		t      time.Time
+		limit  int
+		window time.Duration
+		until  time.Time
+	}{
+		{name: "weekday peak", t: at(15, 10, 0), limit: 100, until: at(15, 17, 0)}, // Friday
+		{name: "weekday evening", t: at(15, 20, 0), until: at(15, 22, 0)},
+		{name: "friday night", t: at(15, 23, 0), limit: 1000, window: time.Hour, until: at(16, 6, 0)},
+		{name: "after midnight", t: at(16, 3, 0), limit: 1000, window: time.Hour, until: at(16, 6, 0)},
+		// No rule applies on the weekend: the next boundary is Monday 9am.
+		{name: "saturday", t: at(16, 10, 0), until: at(18, 9, 0)},
+		{name: "monday morning", t: at(18, 8, 30), until: at(18, 9, 0)},
+	}
+	for _, tt := range tests {
+		kl := s.At(tt.t)
+		if kl.Limit != tt.limit || kl.Window != tt.window || !kl.Until.Equal(tt.until) {
+			t.Errorf("%s: At(%v) = %+v, want limit %v window %v until %v", tt.name, tt.t, kl, tt.limit, tt.window, tt.until)
+		}
+	}
+}
+
+func TestScheduleDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := httprate.NewSchedule(ny, httprate.ScheduleRule{From: 10 * time.Hour, To: 17 * time.Hour, Limit: 100})
+
+	// Clocks go forward at 2am on March 8 and back at 2am on November 1, 2026;
+	// rules still follow the wall clock.
+	for _, day := range []time.Time{
+		time.Date(2026, time.March, 8, 0, 0, 0, 0, ny),
+		time.Date(2026, time.November, 1, 0, 0, 0, 0, ny),
+	} {
+		y, m, d := day.Date()
+		before := time.Date(y, m, d, 9, 30, 0, 0, ny)
+		if kl := s.At(before); kl.Limit != 0 || !kl.Until.Equal(time.Date(y, m, d, 10, 0, 0, 0, ny)) {
+			t.Errorf("At(%v) = %+v, want no limit until 10am", before, kl)
+		}
+		during := time.Date(y, m, d, 10, 30, 0, 0, ny)
+		if kl := s.At(during); kl.Limit != 100 || !kl.Until.Equal(time.Date(y, m, d, 17, 0, 0, 0, ny)) {
+			t.Errorf("At(%v) = %+v, want limit 100 until 5pm", during, kl)
+		}
+	}
+}
+
+func TestScheduleResetHeader(t *testing.T) {
+	// Always matches, with a boundary at midnight UTC.
+	s := httprate.NewSchedule(time.UTC, httprate.ScheduleRule{From: 0, To: 24 * time.Hour, Limit: 5})
+	rl := httprate.NewRateLimiter(100, 48*time.Hour, httprate.WithLimitProvider(s, 0))
+
+	recorder := httptest.NewRecorder()
+	rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "key")
+	if got := recorder.Header().Get("X-RateLimit-Limit"); got != "5" {
+		t.Errorf("X-RateLimit-Limit = %q, want 5", got)
+	}
+	midnight := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if got := recorder.Header().Get("X-RateLimit-Reset"); got != strconv.FormatInt(midnight.Unix(), 10) {
+		t.Errorf("X-RateLimit-Reset = %q, want the schedule boundary %v", got, midnight.Unix())
+	}
+}
+
+func TestCalendarWindow(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	rl := httprate.NewRateLimiter(2, time.Minute, httprate.WithCalendarWindow(httprate.Daily, tokyo))
+	if rl.WindowLength() != 24*time.Hour {
+		t.Errorf("WindowLength() = %v, want 24h", rl.WindowLength())
+	}
+	if err := rl.SetWindow(time.Hour); err == nil {
+		t.Error("SetWindow on a calendar window: want error")
+	}
+
+	now := time.Now().In(tokyo)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, tokyo)
+
+	var recorder *httptest.ResponseRecorder
+	var codes []bool
+	for range 3 {
+		recorder = httptest.NewRecorder()
+		codes = append(codes, rl.OnLimit(recorder, httptest.NewRequest("GET", "/", nil), "tenant"))
+	}
+	if codes[0] || codes[1] || !codes[2] {
+		t.Fatalf("limited = %v, want false, false, true", codes)
+	}
+	if got := recorder.Header().Get("X-RateLimit-Reset"); got != strconv.FormatInt(midnight.Unix(), 10) {
+		t.Errorf("X-RateLimit-Reset = %q, want midnight in Tokyo (%v)", got, midnight.Unix())
+	}
+	retryAfter, _ := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	if want := time.Until(midnight).Seconds(); float64(retryAfter) < want-2 || float64(retryAfter) > want+2 {
+		t.Errorf("Retry-After = %v, want ~%v", retryAfter, want)
+	}
+}
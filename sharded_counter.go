@@ -0,0 +1,346 @@
+package httprate
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// shardReplicas is the number of points a node of weight 1 gets on the hash
+// ring. More points spread keys more evenly at the cost of a larger ring.
+const shardReplicas = 128
+
+var errNoShards = errors.New("httprate: sharded limit counter has no nodes")
+
+// ShardNode is a single LimitCounter taking part in a sharded counter.
+type ShardNode struct {
+	// Name identifies the node on the hash ring. It must be unique and stable
+	// across restarts (e.g. the backend's address), since it decides which keys
+	// the node owns.
+	Name string
+
+	// Counter stores the keys owned by this node.
+	Counter LimitCounter
+
+	// Weight is the node's share of keys relative to the other nodes.
+	// Default: 1
+	Weight int
+}
+
+// NewShardedLimitCounter creates a LimitCounter that distributes keys across
+// the given nodes by consistent hashing, so a remote backend can be scaled
+// horizontally. Adding or removing a node only moves the keys that node gains
+// or loses; every other key stays where it was.
+//
+// Nodes can be any LimitCounter, including NewLocalLimitCounter:
+//
+//	counter, err := httprate.NewShardedLimitCounter(
+//		httprate.ShardNode{Name: "redis-a", Counter: redisA},
+//		httprate.ShardNode{Name: "redis-b", Counter: redisB, Weight: 2},
+//	)
+func NewShardedLimitCounter(nodes ...ShardNode) (*shardedCounter, error) {
+	c := &shardedCounter{}
+	for _, node := range nodes {
+		if err := c.AddNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+var (
+	_ LimitCounter         = (*shardedCounter)(nil)
+	_ LimitCounterBatch    = (*shardedCounter)(nil)
+	_ LimitCounterResetter = (*shardedCounter)(nil)
+	_ LimitCounterTopKeys  = (*shardedCounter)(nil)
+)
+
+type shardedCounter struct {
+	nodes []ShardNode
+	ring  []ringPoint // sorted by hash
+
+	// Last Config() call, replayed on nodes added later.
+	requestLimit int
+	windowLength time.Duration
+	configured   bool
+
+	mu sync.RWMutex
+}
+
+type ringPoint struct {
+	hash uint64
+	node int // index into nodes
+}
+
+// AddNode adds a node to the ring. It returns an error if a node with the same
+// name is already present.
+func (c *shardedCounter) AddNode(node ShardNode) error {
+	if node.Counter == nil {
+		return fmt.Errorf("httprate: shard node %q has no counter", node.Name)
+	}
+	if node.Weight <= 0 {
+		node.Weight = 1
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, n := range c.nodes {
+		if n.Name == node.Name {
+			return fmt.Errorf("httprate: duplicate shard node %q", node.Name)
+		}
+	}
+
+	if c.configured {
+		node.Counter.Config(c.requestLimit, c.windowLength)
+	}
+	c.nodes = append(c.nodes, node)
+	c.rebuild()
+	return nil
+}
+
+// RemoveNode removes the named node from the ring and reports whether it was
+// present. Keys it owned move to the remaining nodes and start counting from
+// zero there.
+func (c *shardedCounter) RemoveNode(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.IndexFunc(c.nodes, func(n ShardNode) bool { return n.Name == name })
+	if i < 0 {
+		return false
+	}
+	c.nodes = slices.Delete(c.nodes, i, i+1)
+	c.rebuild()
+	return true
+}
+
+// Nodes returns the names of the nodes currently on the ring.
+func (c *shardedCounter) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, len(c.nodes))
+	for i, n := range c.nodes {
+		names[i] = n.Name
+	}
+	return names
+}
+
+// rebuild recomputes the ring. Points only depend on node names, so each node
+// keeps its positions no matter which other nodes come and go.
+func (c *shardedCounter) rebuild() {
+	ring := c.ring[:0]
+	for i, node := range c.nodes {
+		for r := 0; r < node.Weight*shardReplicas; r++ {
+			ring = append(ring, ringPoint{
+				hash: xxh3.HashString(node.Name + "#" + strconv.Itoa(r)),
+				node: i,
+			})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int { return cmp.Compare(a.hash, b.hash) })
+	c.ring = ring
+}
+
+// lookup returns the index of the node owning key. Must be called with c.mu held.
+func (c *shardedCounter) lookup(key string) int {
+	h := xxh3.HashString(key)
+	i, _ := slices.BinarySearchFunc(c.ring, h, func(p ringPoint, h uint64) int { return cmp.Compare(p.hash, h) })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].node
+}
+
+func (c *shardedCounter) counterFor(key string) (LimitCounter, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 {
+		return nil, errNoShards
+	}
+	return c.nodes[c.lookup(key)].Counter, nil
+}
+
+// Len returns the total number of keys held by the nodes that report it,
+// i.e. implement interface{ Len() int }.
+func (c *shardedCounter) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var n int
+	for _, node := range c.nodes {
+		if counter, ok := node.Counter.(interface{ Len() int }); ok {
+			n += counter.Len()
+		}
+	}
+	return n
+}
+
+func (c *shardedCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requestLimit = requestLimit
+	c.windowLength = windowLength
+	c.configured = true
+	for _, n := range c.nodes {
+		n.Counter.Config(requestLimit, windowLength)
+	}
+}
+
+func (c *shardedCounter) Increment(key string, currentWindow time.Time) error {
+	return c.IncrementBy(key, currentWindow, 1)
+}
+
+func (c *shardedCounter) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	counter, err := c.counterFor(key)
+	if err != nil {
+		return err
+	}
+	return counter.IncrementBy(key, currentWindow, amount)
+}
+
+func (c *shardedCounter) Get(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	counter, err := c.counterFor(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return counter.Get(key, currentWindow, previousWindow)
+}
+
+// Reset forwards to the node owning key. It returns an error wrapping
+// errors.ErrUnsupported if that node can't reset keys.
+func (c *shardedCounter) Reset(key string, currentWindow, previousWindow time.Time) error {
+	counter, err := c.counterFor(key)
+	if err != nil {
+		return err
+	}
+	resetter, ok := counter.(LimitCounterResetter)
+	if !ok {
+		return fmt.Errorf("httprate: %T cannot reset keys: %w", counter, errors.ErrUnsupported)
+	}
+	return resetter.Reset(key, currentWindow, previousWindow)
+}
+
+// TopKeys merges the top keys of every node, which own disjoint keys. It
+// returns an error wrapping errors.ErrUnsupported if a node can't list keys.
+func (c *shardedCounter) TopKeys(n int, currentWindow time.Time) ([]KeyCount, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var keys []KeyCount
+	for _, node := range c.nodes {
+		top, ok := node.Counter.(LimitCounterTopKeys)
+		if !ok {
+			return nil, fmt.Errorf("httprate: %T cannot list keys: %w", node.Counter, errors.ErrUnsupported)
+		}
+		nodeKeys, err := top.TopKeys(n, currentWindow)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, nodeKeys...)
+	}
+	slices.SortFunc(keys, func(a, b KeyCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	if n >= 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys, nil
+}
+
+// shardBatch holds the keys of a batch owned by one node, along with their
+// positions in the original batch.
+type shardBatch struct {
+	counter LimitCounter
+	keys    []string
+	index   []int
+}
+
+// partition groups keys by the node owning them.
+func (c *shardedCounter) partition(keys []string) ([]*shardBatch, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 {
+		return nil, errNoShards
+	}
+
+	byNode := make(map[int]*shardBatch)
+	var batches []*shardBatch
+	for i, key := range keys {
+		n := c.lookup(key)
+		b, ok := byNode[n]
+		if !ok {
+			b = &shardBatch{counter: c.nodes[n].Counter}
+			byNode[n] = b
+			batches = append(batches, b)
+		}
+		b.keys = append(b.keys, key)
+		b.index = append(b.index, i)
+	}
+	return batches, nil
+}
+
+// GetBatch issues one call per node owning any of keys, batched if the node
+// supports it.
+func (c *shardedCounter) GetBatch(keys []string, currentWindow, previousWindow time.Time) ([]int, []int, error) {
+	batches, err := c.partition(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	curr := make([]int, len(keys))
+	prev := make([]int, len(keys))
+	for _, b := range batches {
+		if batch, ok := b.counter.(LimitCounterBatch); ok {
+			bc, bp, err := batch.GetBatch(b.keys, currentWindow, previousWindow)
+			if err != nil {
+				return nil, nil, err
+			}
+			for j, i := range b.index {
+				curr[i], prev[i] = bc[j], bp[j]
+			}
+			continue
+		}
+		for j, i := range b.index {
+			curr[i], prev[i], err = b.counter.Get(b.keys[j], currentWindow, previousWindow)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return curr, prev, nil
+}
+
+// IncrementBatch issues one call per node owning any of keys, batched if the
+// node supports it.
+func (c *shardedCounter) IncrementBatch(keys []string, currentWindow time.Time, amount int) error {
+	batches, err := c.partition(keys)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range batches {
+		if batch, ok := b.counter.(LimitCounterBatch); ok {
+			if err := batch.IncrementBatch(b.keys, currentWindow, amount); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, key := range b.keys {
+			if err := b.counter.IncrementBy(key, currentWindow, amount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
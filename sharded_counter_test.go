@@ -0,0 +1,137 @@
+package httprate_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestShardedCounter(t *testing.T) {
+	a := httprate.NewLocalLimitCounter(time.Minute)
+	b := httprate.NewLocalLimitCounter(time.Minute)
+	counter, err := httprate.NewShardedLimitCounter(
+		httprate.ShardNode{Name: "a", Counter: a},
+		httprate.ShardNode{Name: "b", Counter: b, Weight: 3},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentWindow := time.Now().UTC().Truncate(time.Minute)
+	previousWindow := currentWindow.Add(-time.Minute)
+
+	const numKeys = 4000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%v", i)
+	}
+	if err := counter.IncrementBatch(keys, currentWindow, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key lives on exactly one node, and the weights are honored.
+	var onA, onB int
+	for _, key := range keys {
+		ca, _, _ := a.Get(key, currentWindow, previousWindow)
+		cb, _, _ := b.Get(key, currentWindow, previousWindow)
+		if ca+cb != 1 {
+			t.Fatalf("%q: counted on a=%v b=%v, want exactly one node", key, ca, cb)
+		}
+		onA += ca
+		onB += cb
+	}
+	if ratio := float64(onB) / float64(onA); ratio < 2 || ratio > 4.5 {
+		t.Errorf("b/a key ratio = %.2f (a=%v, b=%v), want ~3", ratio, onA, onB)
+	}
+
+	curr, _, err := counter.GetBatch(keys, currentWindow, previousWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range curr {
+		if c != 1 {
+			t.Fatalf("%q: GetBatch = %v, want 1", keys[i], c)
+		}
+	}
+
+	// Adding a node only moves keys onto the new node.
+	if err := counter.AddNode(httprate.ShardNode{Name: "c", Counter: httprate.NewLocalLimitCounter(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	var moved, lost int
+	for _, key := range keys {
+		c, _, _ := counter.Get(key, currentWindow, previousWindow)
+		if c == 0 {
+			moved++
+		}
+	}
+	if moved == 0 || moved > numKeys/2 {
+		t.Errorf("moved %v of %v keys after adding a node, want some but well under half", moved, numKeys)
+	}
+
+	// Removing it again restores the original placement.
+	if !counter.RemoveNode("c") {
+		t.Fatal("RemoveNode(c) = false, want true")
+	}
+	for _, key := range keys {
+		c, _, _ := counter.Get(key, currentWindow, previousWindow)
+		if c != 1 {
+			lost++
+		}
+	}
+	if lost != 0 {
+		t.Errorf("%v keys lost their count after removing the added node", lost)
+	}
+
+	if err := counter.AddNode(httprate.ShardNode{Name: "a", Counter: a}); err == nil {
+		t.Error("AddNode with duplicate name: want error")
+	}
+}
+
+func TestShardedCounterTopKeys(t *testing.T) {
+	counter, err := httprate.NewShardedLimitCounter(
+		httprate.ShardNode{Name: "a", Counter: &recordingCounter{}},
+		httprate.ShardNode{Name: "b", Counter: &recordingCounter{}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentWindow := time.Now().UTC().Truncate(time.Minute)
+	for i := range 20 {
+		counter.IncrementBy(fmt.Sprintf("key:%v", i), currentWindow, i)
+	}
+
+	top, err := counter.TopKeys(3, currentWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []httprate.KeyCount{{Key: "key:19", Count: 19}, {Key: "key:18", Count: 18}, {Key: "key:17", Count: 17}}
+	if !slices.Equal(top, want) {
+		t.Errorf("TopKeys(3) = %+v, want %+v", top, want)
+	}
+	if all, _ := counter.TopKeys(-1, currentWindow); len(all) != 20 {
+		t.Errorf("TopKeys(-1) returned %v keys, want 20", len(all))
+	}
+}
+
+func TestShardedCounterNoNodes(t *testing.T) {
+	counter, err := httprate.NewShardedLimitCounter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := httprate.LimitBy(1, time.Minute, httprate.Key("*"), httprate.WithLimitCounter(counter))(h)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if respCode := recorder.Result().StatusCode; respCode != http.StatusPreconditionRequired {
+		t.Errorf("resp.StatusCode = %v, want %v", respCode, http.StatusPreconditionRequired)
+	}
+}